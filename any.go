@@ -0,0 +1,254 @@
+package brass
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+var ErrUnrepresentable = errors.New("value cannot be represented")
+
+// Symbol is the Go value of a symbol atom as converted by ToAny and FromAny, distinguishing it from a string.
+type Symbol string
+
+// OctetsKey is the Go value of an octets map key as converted by ToAny and FromAny since []byte cannot be used
+// as a Go map key.
+type OctetsKey string
+
+// Tagged is the Go value of a tagged value as converted by ToAny and FromAny when its tag has no registered
+// codec.
+type Tagged struct {
+	Tag   string
+	Value any
+}
+
+// ToAny converts the expression to plain Go values:
+//
+//	nil      -> nil
+//	bool     -> bool
+//	integer  -> int64
+//	float    -> float64
+//	string   -> string
+//	octets   -> []byte, or OctetsKey as a map key
+//	symbol   -> Symbol
+//	list     -> []any
+//	map      -> map[any]any
+//	tagged   -> the value converted by its registered codec, or else Tagged
+//	raw      -> RawSExpr
+func (e *SExpr) ToAny() any {
+	switch e.kind {
+	case KindNil:
+		return nil
+	case KindBool:
+		return e.integer != 0
+	case KindInteger:
+		return e.integer
+	case KindFloat:
+		return math.Float64frombits(uint64(e.integer))
+	case KindString:
+		return e.octets
+	case KindOctets:
+		return []byte(e.octets)
+	case KindSymbol:
+		return Symbol(e.octets)
+	case KindList:
+		list := make([]any, len(e.list))
+		for i, c := range e.list {
+			list[i] = c.ToAny()
+		}
+		return list
+	case KindMap:
+		if e.omap != nil {
+			m := make(map[any]any, len(e.omap.entries))
+			for _, en := range e.omap.entries {
+				m[en.Key.toAny()] = en.Value.ToAny()
+			}
+			return m
+		}
+		m := make(map[any]any, len(e.dict))
+		for k, v := range e.dict {
+			m[k.toAny()] = v.ToAny()
+		}
+		return m
+	case KindTagged:
+		if e.value != nil {
+			return e.value
+		}
+		return Tagged{Tag: e.octets, Value: e.tagged.ToAny()}
+	case KindRaw:
+		return RawSExpr(e.octets)
+	default:
+		panic(fmt.Errorf("unimplemented kind"))
+	}
+}
+
+// toAny converts a map key to a comparable Go value.
+func (e *SExprPrimitive) toAny() any {
+	switch e.kind {
+	case KindNil:
+		return nil
+	case KindBool:
+		return e.integer != 0
+	case KindInteger:
+		return e.integer
+	case KindFloat:
+		return math.Float64frombits(uint64(e.integer))
+	case KindString:
+		return e.octets
+	case KindOctets:
+		return OctetsKey(e.octets)
+	case KindSymbol:
+		return Symbol(e.octets)
+	default:
+		panic(fmt.Errorf("unimplemented kind"))
+	}
+}
+
+// FromAny converts plain Go values to an expression. It accepts the values returned by ToAny along with all Go
+// integer and float types, map[string]any, values whose type has a codec registered in DefaultTagRegistry, and
+// *SExpr which is used as is. Map keys must convert to primitives. Integers beyond MinInteger..MaxInteger fail
+// with ErrIntegerRange as by NewInt64 and other values which cannot be represented fail with ErrUnrepresentable,
+// along with their path.
+func FromAny(v any) (*SExpr, error) {
+	return fromAny(make(Path, 0, 8), v)
+}
+
+func fromAny(path Path, v any) (e *SExpr, err error) {
+	switch x := v.(type) {
+	case nil:
+		return MakeNil(), nil
+	case *SExpr:
+		return x, nil
+	case bool:
+		return MakeBool(x), nil
+	case int:
+		return fromInt64(path, int64(x))
+	case int8:
+		return fromInt64(path, int64(x))
+	case int16:
+		return fromInt64(path, int64(x))
+	case int32:
+		return fromInt64(path, int64(x))
+	case int64:
+		return fromInt64(path, x)
+	case uint:
+		return fromUint64(path, uint64(x))
+	case uint8:
+		return fromUint64(path, uint64(x))
+	case uint16:
+		return fromUint64(path, uint64(x))
+	case uint32:
+		return fromUint64(path, uint64(x))
+	case uint64:
+		return fromUint64(path, x)
+	case float32:
+		return MakeFloat64(float64(x)), nil
+	case float64:
+		return MakeFloat64(x), nil
+	case string:
+		return MakeString(x), nil
+	case []byte:
+		return MakeOctets(x), nil
+	case OctetsKey:
+		return &SExpr{kind: KindOctets, octets: string(x)}, nil
+	case Symbol:
+		if !IsValidSymbol(string(x)) {
+			err = atPath(path, fmt.Errorf("%w: invalid symbol %q", ErrUnrepresentable, x))
+			return
+		}
+		return MakeSymbol(string(x)), nil
+	case RawSExpr:
+		return MakeRaw(x), nil
+	case Tagged:
+		if !IsValidSymbol(x.Tag) {
+			err = atPath(path, ErrInvalidTag)
+			return
+		}
+		var inner *SExpr
+		inner, err = fromAny(path, x.Value)
+		if err != nil {
+			return
+		}
+		return MakeTagged(x.Tag, inner), nil
+	case []any:
+		list := make([]*SExpr, len(x))
+		for i, c := range x {
+			list[i], err = fromAny(append(path, PathElem{Index: i}), c)
+			if err != nil {
+				return
+			}
+		}
+		return MakeList(list), nil
+	case map[any]any:
+		dict := make(map[SExprPrimitive]*SExpr, len(x))
+		for k, c := range x {
+			err = fromAnyEntry(path, dict, k, c)
+			if err != nil {
+				return
+			}
+		}
+		return MakeMap(dict), nil
+	case map[string]any:
+		dict := make(map[SExprPrimitive]*SExpr, len(x))
+		for k, c := range x {
+			err = fromAnyEntry(path, dict, k, c)
+			if err != nil {
+				return
+			}
+		}
+		return MakeMap(dict), nil
+	default:
+		e, err = MakeTaggedValue(v)
+		if errors.Is(err, ErrUnregisteredType) {
+			err = atPath(path, fmt.Errorf("%w: %T", ErrUnrepresentable, v))
+			return
+		}
+		if err != nil {
+			err = atPath(path, err)
+			return
+		}
+		return
+	}
+}
+
+func fromInt64(path Path, v int64) (e *SExpr, err error) {
+	if e, err = NewInt64(v); err != nil {
+		err = atPath(path, err)
+	}
+	return
+}
+
+func fromUint64(path Path, v uint64) (e *SExpr, err error) {
+	if e, err = NewUint64(v); err != nil {
+		err = atPath(path, err)
+	}
+	return
+}
+
+// fromAnyEntry converts a Go map entry and adds it to dict.
+func fromAnyEntry(path Path, dict map[SExprPrimitive]*SExpr, k, v any) (err error) {
+	var key *SExpr
+	key, err = fromAny(path, k)
+	if err != nil {
+		return
+	}
+
+	switch key.kind {
+	case KindNil, KindBool, KindInteger, KindFloat, KindString, KindOctets, KindSymbol:
+	default:
+		err = atPath(path, fmt.Errorf("%w: map key of type %T", ErrNotPrimitive, k))
+		return
+	}
+
+	p := SExprPrimitive{kind: key.kind, integer: key.integer, octets: key.octets}
+	dict[p], err = fromAny(append(path, PathElem{IsKey: true, Key: p}), v)
+	return
+}
+
+// atPath prefixes an error with the path at which it occurred.
+func atPath(path Path, err error) error {
+	if len(path) == 0 {
+		return err
+	}
+	return fmt.Errorf("%s: %w", path, err)
+}
@@ -0,0 +1,138 @@
+package brass
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSExpr_ToAny(t *testing.T) {
+	e, _, err := DecodeBytes([]byte(`(nil true $10 -$1 %3ff8000000000000 "abc" #2$0102 read (@uuid(#1$ff)) {("a" $1) (#1$00 $2) (sym $3) ($4 ())})`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []any{
+		nil,
+		true,
+		int64(16),
+		int64(-1),
+		1.5,
+		"abc",
+		[]byte{1, 2},
+		Symbol("read"),
+		[]any{Tagged{Tag: "uuid", Value: []byte{0xff}}},
+		map[any]any{
+			"a":               int64(1),
+			OctetsKey("\x00"): int64(2),
+			Symbol("sym"):     int64(3),
+			int64(4):          []any{},
+		},
+	}
+	if got := e.ToAny(); !reflect.DeepEqual(got, want) {
+		t.Errorf("ToAny() = %#v, want %#v", got, want)
+	}
+
+	// round-trip:
+	e2, err := FromAny(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(e2, e) {
+		t.Errorf("FromAny() = %s, want %s", e2, e)
+	}
+}
+
+func TestFromAny(t *testing.T) {
+	r := DefaultTagRegistry
+	DefaultTagRegistry = testTagRegistry(t)
+	defer func() { DefaultTagRegistry = r }()
+
+	tests := []struct {
+		name    string
+		v       any
+		want    string
+		wantErr error
+	}{
+		{
+			name: "numbers",
+			v:    []any{int8(-1), uint16(2), uint64(3), float32(0.5), math.Inf(-1)},
+			want: `(-$1 $2 $3 %3fe0000000000000 %fff0000000000000)`,
+		},
+		{
+			name: "string keys",
+			v:    map[string]any{"a": []any{}},
+			want: `{("a" ())}`,
+		},
+		{
+			name: "sexpr",
+			v:    []any{MakeSymbol("x"), RawSExpr(`($1)`)},
+			want: `(x ($1))`,
+		},
+		{
+			name: "registered tag",
+			v:    []any{time.Unix(16, 0)},
+			want: `(@time($10))`,
+		},
+		{
+			name:    "uint64 range",
+			v:       []any{uint64(math.MaxUint64)},
+			want:    "[0]",
+			wantErr: ErrIntegerRange,
+		},
+		{
+			name:    "int64 range",
+			v:       map[string]any{"a": []any{int64(MaxInteger), int64(MinInteger - 1)}},
+			want:    `["a"][1]`,
+			wantErr: ErrIntegerRange,
+		},
+		{
+			name:    "uint range",
+			v:       []any{uint(MaxInteger + 1)},
+			want:    "[0]",
+			wantErr: ErrIntegerRange,
+		},
+		{
+			name:    "unsupported type",
+			v:       map[string]any{"a": []any{nil, make(chan int)}},
+			want:    `["a"][1]`,
+			wantErr: ErrUnrepresentable,
+		},
+		{
+			name:    "invalid symbol",
+			v:       Symbol("nil"),
+			wantErr: ErrUnrepresentable,
+		},
+		{
+			name:    "invalid tag",
+			v:       Tagged{Tag: "1x"},
+			wantErr: ErrInvalidTag,
+		},
+		{
+			name:    "non-primitive key",
+			v:       map[any]any{Tagged{Tag: "x"}: nil},
+			wantErr: ErrNotPrimitive,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := FromAny(tt.v)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("FromAny() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				// errors report the path of the offending value:
+				if !strings.HasPrefix(err.Error(), tt.want) {
+					t.Errorf("FromAny() error = %v, want path %s", err, tt.want)
+				}
+				return
+			}
+			if got := e.String(); got != tt.want {
+				t.Errorf("FromAny() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,352 @@
+package brass
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+var ErrWrongKind = errors.New("wrong kind")
+var ErrIntegerRange = errors.New("integer exceeds 52 bits")
+var ErrInvalidSymbol = errors.New("invalid symbol")
+var ErrNilExpr = errors.New("nil expression")
+
+// MinInteger and MaxInteger bound the integers allowed by the encoding which are at most 52 bits in magnitude so
+// that they are exactly representable by all implementations, including those which only have float64 numbers.
+const (
+	MaxInteger = 1<<52 - 1
+	MinInteger = -MaxInteger
+)
+
+// checkKind fails with ErrWrongKind unless got is want.
+func checkKind(got, want Kind) error {
+	if got != want {
+		return fmt.Errorf("%w: expected %v, got %v", ErrWrongKind, want, got)
+	}
+	return nil
+}
+
+func isPrimitiveKind(k Kind) bool {
+	switch k {
+	case KindNil, KindBool, KindInteger, KindFloat, KindString, KindOctets, KindSymbol:
+		return true
+	default:
+		return false
+	}
+}
+
+func (e *SExpr) IsBool() bool      { return e.kind == KindBool }
+func (e *SExpr) IsInteger() bool   { return e.kind == KindInteger }
+func (e *SExpr) IsFloat() bool     { return e.kind == KindFloat }
+func (e *SExpr) IsString() bool    { return e.kind == KindString }
+func (e *SExpr) IsOctets() bool    { return e.kind == KindOctets }
+func (e *SExpr) IsSymbol() bool    { return e.kind == KindSymbol }
+func (e *SExpr) IsList() bool      { return e.kind == KindList }
+func (e *SExpr) IsMap() bool       { return e.kind == KindMap }
+func (e *SExpr) IsTagged() bool    { return e.kind == KindTagged }
+func (e *SExpr) IsRaw() bool       { return e.kind == KindRaw }
+func (e *SExpr) IsPrimitive() bool { return isPrimitiveKind(e.kind) }
+
+// Bool is like AsBool but returns ErrWrongKind instead of panicking.
+func (e *SExpr) Bool() (v bool, err error) {
+	if err = checkKind(e.kind, KindBool); err != nil {
+		return
+	}
+	v = e.integer != 0
+	return
+}
+
+// Int64 is like AsInt64 but returns ErrWrongKind instead of panicking.
+func (e *SExpr) Int64() (v int64, err error) {
+	if err = checkKind(e.kind, KindInteger); err != nil {
+		return
+	}
+	v = e.integer
+	return
+}
+
+// Float64 is like AsFloat64 but returns ErrWrongKind instead of panicking.
+func (e *SExpr) Float64() (v float64, err error) {
+	if err = checkKind(e.kind, KindFloat); err != nil {
+		return
+	}
+	v = math.Float64frombits(uint64(e.integer))
+	return
+}
+
+// Str is like AsString but returns ErrWrongKind instead of panicking.
+func (e *SExpr) Str() (v string, err error) {
+	if err = checkKind(e.kind, KindString); err != nil {
+		return
+	}
+	v = e.octets
+	return
+}
+
+// Octets is like AsOctets but returns ErrWrongKind instead of panicking.
+func (e *SExpr) Octets() (v []byte, err error) {
+	if err = checkKind(e.kind, KindOctets); err != nil {
+		return
+	}
+	v = []byte(e.octets)
+	return
+}
+
+// Symbol is like AsSymbol but returns ErrWrongKind instead of panicking.
+func (e *SExpr) Symbol() (v string, err error) {
+	if err = checkKind(e.kind, KindSymbol); err != nil {
+		return
+	}
+	v = e.octets
+	return
+}
+
+// List is like AsList but returns ErrWrongKind instead of panicking.
+func (e *SExpr) List() (v []*SExpr, err error) {
+	if err = checkKind(e.kind, KindList); err != nil {
+		return
+	}
+	v = e.list
+	return
+}
+
+// Map is like AsMap but returns ErrWrongKind instead of panicking. The entries of an ordered map are returned in a
+// new Go map, leaving the expression unchanged.
+func (e *SExpr) Map() (v map[SExprPrimitive]*SExpr, err error) {
+	if err = checkKind(e.kind, KindMap); err != nil {
+		return
+	}
+	if e.omap != nil {
+		v = e.omap.toMap()
+		return
+	}
+	v = e.dict
+	return
+}
+
+// Tagged is like AsTagged but returns ErrWrongKind instead of panicking.
+func (e *SExpr) Tagged() (tag string, inner *SExpr, err error) {
+	if err = checkKind(e.kind, KindTagged); err != nil {
+		return
+	}
+	tag, inner = e.octets, e.tagged
+	return
+}
+
+// Raw is like AsRaw but returns ErrWrongKind instead of panicking.
+func (e *SExpr) Raw() (v RawSExpr, err error) {
+	if err = checkKind(e.kind, KindRaw); err != nil {
+		return
+	}
+	v = RawSExpr(e.octets)
+	return
+}
+
+// Primitive converts the expression to a primitive for use as a map key or returns ErrNotPrimitive.
+func (e *SExpr) Primitive() (p SExprPrimitive, err error) {
+	if !isPrimitiveKind(e.kind) {
+		err = fmt.Errorf("%w: %v", ErrNotPrimitive, e.kind)
+		return
+	}
+	p = SExprPrimitive{kind: e.kind, integer: e.integer, octets: e.octets}
+	return
+}
+
+func (e *SExprPrimitive) IsBool() bool    { return e.kind == KindBool }
+func (e *SExprPrimitive) IsInteger() bool { return e.kind == KindInteger }
+func (e *SExprPrimitive) IsFloat() bool   { return e.kind == KindFloat }
+func (e *SExprPrimitive) IsString() bool  { return e.kind == KindString }
+func (e *SExprPrimitive) IsOctets() bool  { return e.kind == KindOctets }
+func (e *SExprPrimitive) IsSymbol() bool  { return e.kind == KindSymbol }
+
+// Bool is like AsBool but returns ErrWrongKind instead of panicking.
+func (e *SExprPrimitive) Bool() (v bool, err error) {
+	if err = checkKind(e.kind, KindBool); err != nil {
+		return
+	}
+	v = e.integer != 0
+	return
+}
+
+// Int64 is like AsInt64 but returns ErrWrongKind instead of panicking.
+func (e *SExprPrimitive) Int64() (v int64, err error) {
+	if err = checkKind(e.kind, KindInteger); err != nil {
+		return
+	}
+	v = e.integer
+	return
+}
+
+// Float64 is like AsFloat64 but returns ErrWrongKind instead of panicking.
+func (e *SExprPrimitive) Float64() (v float64, err error) {
+	if err = checkKind(e.kind, KindFloat); err != nil {
+		return
+	}
+	v = math.Float64frombits(uint64(e.integer))
+	return
+}
+
+// Str is like AsString but returns ErrWrongKind instead of panicking.
+func (e *SExprPrimitive) Str() (v string, err error) {
+	if err = checkKind(e.kind, KindString); err != nil {
+		return
+	}
+	v = e.octets
+	return
+}
+
+// Octets is like AsOctets but returns ErrWrongKind instead of panicking.
+func (e *SExprPrimitive) Octets() (v []byte, err error) {
+	if err = checkKind(e.kind, KindOctets); err != nil {
+		return
+	}
+	v = []byte(e.octets)
+	return
+}
+
+// Symbol is like AsSymbol but returns ErrWrongKind instead of panicking.
+func (e *SExprPrimitive) Symbol() (v string, err error) {
+	if err = checkKind(e.kind, KindSymbol); err != nil {
+		return
+	}
+	v = e.octets
+	return
+}
+
+// The New* constructors are like their Make* counterparts but validate their arguments so that they cannot
+// produce expressions which other implementations fail to decode.
+
+// NewInt64 makes an integer or returns ErrIntegerRange if v is beyond MinInteger..MaxInteger.
+func NewInt64(v int64) (*SExpr, error) {
+	if v < MinInteger || v > MaxInteger {
+		return nil, fmt.Errorf("%w: %d", ErrIntegerRange, v)
+	}
+	return MakeInt64(v), nil
+}
+
+// NewUint64 makes an integer or returns ErrIntegerRange if v is beyond MaxInteger.
+func NewUint64(v uint64) (*SExpr, error) {
+	if v > MaxInteger {
+		return nil, fmt.Errorf("%w: %d", ErrIntegerRange, v)
+	}
+	return MakeInt64(int64(v)), nil
+}
+
+// NewSymbol makes a symbol or returns ErrInvalidSymbol if name is not a valid symbol.
+func NewSymbol(name string) (*SExpr, error) {
+	if !IsValidSymbol(name) {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidSymbol, name)
+	}
+	return MakeSymbol(name), nil
+}
+
+// NewList makes a list or returns ErrNilExpr if any element is nil.
+func NewList(v ...*SExpr) (*SExpr, error) {
+	for i, c := range v {
+		if c == nil {
+			return nil, fmt.Errorf("[%d]: %w", i, ErrNilExpr)
+		}
+	}
+	return MakeList(v), nil
+}
+
+// NewMap makes a map from alternating keys and values. It fails with ErrNotPrimitive if a key is not a primitive
+// and ErrNilExpr if a key or value is nil or if a key has no value.
+func NewMap(kv ...*SExpr) (*SExpr, error) {
+	if len(kv)%2 != 0 {
+		return nil, fmt.Errorf("%w: key without value", ErrNilExpr)
+	}
+
+	dict := make(map[SExprPrimitive]*SExpr, len(kv)/2)
+	for i := 0; i < len(kv); i += 2 {
+		k, v := kv[i], kv[i+1]
+		if k == nil || v == nil {
+			return nil, fmt.Errorf("entry %d: %w", i/2, ErrNilExpr)
+		}
+
+		p, err := k.Primitive()
+		if err != nil {
+			return nil, fmt.Errorf("entry %d: %w", i/2, err)
+		}
+		dict[p] = v
+	}
+	return MakeMap(dict), nil
+}
+
+// NewTagged makes a tagged value or returns ErrInvalidTag if tag is not a valid symbol and ErrNilExpr if inner
+// is nil.
+func NewTagged(tag string, inner *SExpr) (*SExpr, error) {
+	if !IsValidSymbol(tag) {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidTag, tag)
+	}
+	if inner == nil {
+		return nil, ErrNilExpr
+	}
+	return MakeTagged(tag, inner), nil
+}
+
+// Validate checks that the tree rooted at e can be decoded by all implementations: all integers are within
+// MinInteger..MaxInteger, symbols and tags are valid and no expression is nil. The path of the first invalid
+// expression is reported in the error.
+func (e *SExpr) Validate() error {
+	if e == nil {
+		return ErrNilExpr
+	}
+
+	return Walk(e, func(path Path, x *SExpr) (err error) {
+		switch x.kind {
+		case KindInteger:
+			if x.integer < MinInteger || x.integer > MaxInteger {
+				err = fmt.Errorf("%w: %d", ErrIntegerRange, x.integer)
+			}
+		case KindSymbol:
+			if !IsValidSymbol(x.octets) {
+				err = fmt.Errorf("%w: %q", ErrInvalidSymbol, x.octets)
+			}
+		case KindList:
+			for i, c := range x.list {
+				if c == nil {
+					err = fmt.Errorf("[%d]: %w", i, ErrNilExpr)
+					break
+				}
+			}
+		case KindMap:
+			for _, en := range mapEntries(x) {
+				err = validatePrimitive(&en.Key)
+				if err == nil && en.Value == nil {
+					err = ErrNilExpr
+				}
+				if err != nil {
+					err = atPath(Path{{IsKey: true, Key: en.Key}}, err)
+					break
+				}
+			}
+		case KindTagged:
+			if !IsValidSymbol(x.octets) {
+				err = fmt.Errorf("%w: %q", ErrInvalidTag, x.octets)
+			} else if x.tagged == nil {
+				err = ErrNilExpr
+			}
+		}
+
+		if err != nil {
+			err = atPath(path, err)
+		}
+		return
+	}, nil)
+}
+
+// validatePrimitive checks a map key as Validate does.
+func validatePrimitive(p *SExprPrimitive) error {
+	switch p.kind {
+	case KindInteger:
+		if p.integer < MinInteger || p.integer > MaxInteger {
+			return fmt.Errorf("%w: %d", ErrIntegerRange, p.integer)
+		}
+	case KindSymbol:
+		if !IsValidSymbol(p.octets) {
+			return fmt.Errorf("%w: %q", ErrInvalidSymbol, p.octets)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+package brass
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSExpr_CheckedAccessors(t *testing.T) {
+	e, _, err := DecodeBytes([]byte(`(true $10 %3ff8000000000000 "abc" #1$ff sym {("a" $1)} @t($1))`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	list, err := e.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v, err := list[0].Bool(); err != nil || !v {
+		t.Errorf("Bool() = %v, %v", v, err)
+	}
+	if v, err := list[1].Int64(); err != nil || v != 16 {
+		t.Errorf("Int64() = %v, %v", v, err)
+	}
+	if v, err := list[2].Float64(); err != nil || v != 1.5 {
+		t.Errorf("Float64() = %v, %v", v, err)
+	}
+	if v, err := list[3].Str(); err != nil || v != "abc" {
+		t.Errorf("Str() = %v, %v", v, err)
+	}
+	if v, err := list[4].Octets(); err != nil || string(v) != "\xff" {
+		t.Errorf("Octets() = %v, %v", v, err)
+	}
+	if v, err := list[5].Symbol(); err != nil || v != "sym" {
+		t.Errorf("Symbol() = %v, %v", v, err)
+	}
+	if v, err := list[6].Map(); err != nil || len(v) != 1 {
+		t.Errorf("Map() = %v, %v", v, err)
+	}
+	if tag, inner, err := list[7].Tagged(); err != nil || tag != "t" || inner.AsInt64() != 1 {
+		t.Errorf("Tagged() = %v, %v, %v", tag, inner, err)
+	}
+
+	// reading an ordered map leaves it ordered:
+	ordered := MakeOrderedMap(NewOrderedMap(2))
+	ordered.AsOrderedMap().Set(PrimitiveString("b"), MakeInt64(1))
+	ordered.AsOrderedMap().Set(PrimitiveString("a"), MakeInt64(2))
+	if v, err := ordered.Map(); err != nil || len(v) != 2 || v[PrimitiveString("a")].AsInt64() != 2 {
+		t.Errorf("Map() = %v, %v", v, err)
+	}
+	if !ordered.IsOrderedMap() || ordered.String() != `{("b" $1) ("a" $2)}` {
+		t.Errorf("Map() changed the ordered map to %v", ordered)
+	}
+
+	// mismatches return errors instead of panicking:
+	for _, c := range list {
+		if c.IsInteger() {
+			continue
+		}
+		if _, err := c.Int64(); !errors.Is(err, ErrWrongKind) {
+			t.Errorf("Int64() on %v error = %v, want %v", c.Kind(), err, ErrWrongKind)
+		}
+	}
+	if _, err := list[1].Str(); err == nil || err.Error() != "wrong kind: expected KindString, got KindInteger" {
+		t.Errorf("Str() error = %v", err)
+	}
+
+	p, err := list[3].Primitive()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := p.Str(); err != nil || v != "abc" {
+		t.Errorf("SExprPrimitive.Str() = %v, %v", v, err)
+	}
+	if _, err := p.Bool(); !errors.Is(err, ErrWrongKind) {
+		t.Errorf("SExprPrimitive.Bool() error = %v, want %v", err, ErrWrongKind)
+	}
+	if _, err := list[6].Primitive(); !errors.Is(err, ErrNotPrimitive) {
+		t.Errorf("Primitive() error = %v, want %v", err, ErrNotPrimitive)
+	}
+}
+
+func TestCheckedConstructors(t *testing.T) {
+	if _, err := NewInt64(MaxInteger); err != nil {
+		t.Errorf("NewInt64(MaxInteger) error = %v", err)
+	}
+	if _, err := NewInt64(MinInteger - 1); !errors.Is(err, ErrIntegerRange) {
+		t.Errorf("NewInt64() error = %v, want %v", err, ErrIntegerRange)
+	}
+	if e, err := NewUint64(MaxInteger); err != nil || e.AsInt64() != MaxInteger {
+		t.Errorf("NewUint64(MaxInteger) = %v, %v", e, err)
+	}
+	if _, err := NewUint64(MaxInteger + 1); !errors.Is(err, ErrIntegerRange) {
+		t.Errorf("NewUint64() error = %v, want %v", err, ErrIntegerRange)
+	}
+	if _, err := NewSymbol("true"); !errors.Is(err, ErrInvalidSymbol) {
+		t.Errorf("NewSymbol() error = %v, want %v", err, ErrInvalidSymbol)
+	}
+	if _, err := NewList(MakeNil(), nil); !errors.Is(err, ErrNilExpr) {
+		t.Errorf("NewList() error = %v, want %v", err, ErrNilExpr)
+	}
+	if _, err := NewTagged("9", MakeNil()); !errors.Is(err, ErrInvalidTag) {
+		t.Errorf("NewTagged() error = %v, want %v", err, ErrInvalidTag)
+	}
+
+	m, err := NewMap(MakeString("a"), MakeInt64(1), MakeSymbol("b"), MakeList(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := m.AsMap()[PrimitiveSymbol("b")].String(), `()`; got != want {
+		t.Errorf("NewMap() value = %s, want %s", got, want)
+	}
+	if _, err = NewMap(MakeList(nil), MakeNil()); !errors.Is(err, ErrNotPrimitive) {
+		t.Errorf("NewMap() error = %v, want %v", err, ErrNotPrimitive)
+	}
+	if _, err = NewMap(MakeString("a")); !errors.Is(err, ErrNilExpr) {
+		t.Errorf("NewMap() error = %v, want %v", err, ErrNilExpr)
+	}
+}
+
+func TestSExpr_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		e       *SExpr
+		wantErr error
+		wantMsg string
+	}{
+		{
+			name: "valid",
+			e:    MakeList([]*SExpr{MakeInt64(MinInteger), MakeTagged("t", MakeSymbol("s"))}),
+		},
+		{
+			name:    "integer range",
+			e:       MakeList([]*SExpr{MakeNil(), MakeList([]*SExpr{MakeInt64(1 << 52)})}),
+			wantErr: ErrIntegerRange,
+			wantMsg: "[1][0]: integer exceeds 52 bits: 4503599627370496",
+		},
+		{
+			name:    "map key range",
+			e:       MakeMap(map[SExprPrimitive]*SExpr{PrimitiveInt64(-1 << 60): MakeNil()}),
+			wantErr: ErrIntegerRange,
+		},
+		{
+			name:    "nil map value",
+			e:       MakeMap(map[SExprPrimitive]*SExpr{PrimitiveString("a"): nil}),
+			wantErr: ErrNilExpr,
+			wantMsg: `["a"]: nil expression`,
+		},
+		{
+			name:    "invalid symbol in tagged",
+			e:       MakeList([]*SExpr{MakeTagged("t", &SExpr{kind: KindSymbol, octets: "1"})}),
+			wantErr: ErrInvalidSymbol,
+			wantMsg: `[0]@t: invalid symbol: "1"`,
+		},
+		{
+			name:    "nil list element",
+			e:       MakeList([]*SExpr{nil}),
+			wantErr: ErrNilExpr,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.e.Validate()
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Validate() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantMsg != "" && err.Error() != tt.wantMsg {
+				t.Errorf("Validate() error = %q, want %q", err, tt.wantMsg)
+			}
+		})
+	}
+}
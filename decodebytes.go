@@ -0,0 +1,606 @@
+package brass
+
+import (
+	"io"
+	"math"
+	"strconv"
+	"unsafe"
+)
+
+// unhex maps an ASCII character to its hex digit value or to 0xff if it is not a hex digit.
+var unhex = func() (t [256]byte) {
+	for i := range t {
+		t[i] = 0xff
+	}
+	for c := '0'; c <= '9'; c++ {
+		t[c] = byte(c - '0')
+	}
+	for c := 'a'; c <= 'f'; c++ {
+		t[c] = byte(c - 'a' + 10)
+	}
+	return
+}()
+
+// DecodeBytes decodes the top-level list at the start of b and returns it along with the number of bytes of b
+// consumed. It is equivalent to decoding with a Decoder but scans the slice directly which is considerably
+// faster. Strings and octets are copied out of b so b may be reused after DecodeBytes returns.
+func DecodeBytes(b []byte) (e *SExpr, n int, err error) {
+	d := bytesDecoder{b: b}
+	return d.decode()
+}
+
+// DecodeBytesAliased is like DecodeBytes but avoids copying strings and octets by decoding them in place in b
+// and aliasing the decoded values into b. b is overwritten in the process and must not be modified afterwards
+// for as long as the returned expression, or any string or octets taken from it, is in use.
+func DecodeBytesAliased(b []byte) (e *SExpr, n int, err error) {
+	d := bytesDecoder{b: b, alias: true}
+	return d.decode()
+}
+
+type bytesDecoder struct {
+	b     []byte
+	i     int
+	alias bool
+
+	// labels holds the expressions of labels defined so far:
+	labels []label
+	// ref is the labeled expression most recently referred to, to be used in place of the decoded node:
+	ref *SExpr
+}
+
+// bytesToString converts b to a string without copying; b must not be modified afterwards.
+func bytesToString(b []byte) string {
+	return *(*string)(unsafe.Pointer(&b))
+}
+
+func (d *bytesDecoder) decode() (e *SExpr, n int, err error) {
+	if len(d.b) == 0 {
+		err = io.EOF
+		return
+	}
+	if d.b[0] != '(' {
+		err = ErrUnexpectedCharacter
+		return
+	}
+
+	e = &SExpr{}
+	err = d.decodeList(e)
+	n = d.i
+	if err != nil {
+		return
+	}
+
+	if len(d.labels) > 0 {
+		err = checkExpansion(e, d.labels, 0)
+	}
+	return
+}
+
+// shared returns the labeled expression if a reference was decoded in place of e or else e itself.
+func (d *bytesDecoder) shared(e *SExpr) *SExpr {
+	if d.ref == nil {
+		return e
+	}
+
+	e, d.ref = d.ref, nil
+	return e
+}
+
+// next skips whitespace and returns the next character without consuming it.
+func (d *bytesDecoder) next() (c byte, err error) {
+	for d.i < len(d.b) {
+		c = d.b[d.i]
+		if c != ' ' {
+			return
+		}
+		d.i++
+	}
+
+	err = io.ErrUnexpectedEOF
+	return
+}
+
+func (d *bytesDecoder) decodeList(e *SExpr) (err error) {
+	// skip '(':
+	d.i++
+
+	e.kind = KindList
+	e.list = make([]*SExpr, 0, 10)
+	for {
+		var c byte
+		c, err = d.next()
+		if err != nil {
+			return
+		}
+		if c == ')' {
+			d.i++
+			return
+		}
+
+		child := &SExpr{}
+		err = d.decodeNode(child)
+		if err != nil {
+			return
+		}
+		child = d.shared(child)
+
+		e.list = append(e.list, child)
+	}
+}
+
+func (d *bytesDecoder) decodeMap(e *SExpr) (err error) {
+	// skip '{':
+	d.i++
+
+	e.kind = KindMap
+	e.dict = make(map[SExprPrimitive]*SExpr, 10)
+	for {
+		var c byte
+		c, err = d.next()
+		if err != nil {
+			return
+		}
+		if c == '}' {
+			d.i++
+			return
+		}
+		if c != '(' {
+			err = ErrUnexpectedCharacter
+			return
+		}
+		d.i++
+
+		var key SExprPrimitive
+		key, err = d.decodePrimitive()
+		if err != nil {
+			return
+		}
+
+		value := &SExpr{}
+		err = d.decodeNode(value)
+		if err != nil {
+			return
+		}
+		value = d.shared(value)
+
+		c, err = d.next()
+		if err != nil {
+			return
+		}
+		if c != ')' {
+			err = ErrUnexpectedCharacter
+			return
+		}
+		d.i++
+
+		e.dict[key] = value
+	}
+}
+
+func (d *bytesDecoder) decodeNode(e *SExpr) (err error) {
+	var c byte
+	c, err = d.next()
+	if err != nil {
+		return
+	}
+
+	if c == '(' {
+		return d.decodeList(e)
+	}
+	if c == '{' {
+		return d.decodeMap(e)
+	}
+	if c == '@' {
+		return d.decodeTagged(e)
+	}
+	if c == '&' {
+		return d.decodeLabel(e)
+	}
+	if c == '*' {
+		return d.decodeReference()
+	}
+
+	var p SExprPrimitive
+	p, err = d.decodePrimitive()
+	if err != nil {
+		return
+	}
+
+	e.kind = p.kind
+	e.integer = p.integer
+	e.octets = p.octets
+	return
+}
+
+func (d *bytesDecoder) decodePrimitive() (p SExprPrimitive, err error) {
+	var c byte
+	c, err = d.next()
+	if err != nil {
+		return
+	}
+
+	switch c {
+	case '(', '{', '@', '&', '*':
+		err = ErrNotPrimitive
+		return
+	case '"':
+		d.i++
+		p.kind = KindString
+		p.octets, err = d.decodeString()
+		return
+	case '#':
+		d.i++
+		p.kind = KindOctets
+		p.octets, err = d.decodeHexOctets()
+		return
+	case '%':
+		d.i++
+		p.kind = KindFloat
+		p.integer, err = d.decodeFloat()
+		return
+	case '-':
+		d.i++
+		if d.i >= len(d.b) {
+			err = io.ErrUnexpectedEOF
+			return
+		}
+		if d.b[d.i] != '$' {
+			err = ErrUnexpectedCharacter
+			return
+		}
+		d.i++
+
+		var v uint64
+		v, err = d.decodeHex(1 << 63)
+		p.kind = KindInteger
+		p.integer = -int64(v)
+		return
+	case '$':
+		d.i++
+
+		var v uint64
+		v, err = d.decodeHex(1<<63 - 1)
+		p.kind = KindInteger
+		p.integer = int64(v)
+		return
+	default:
+		if isSymbolStart(c) {
+			err = d.decodeIdentifier(&p)
+			return
+		}
+
+		err = ErrUnexpectedCharacter
+		return
+	}
+}
+
+func (d *bytesDecoder) decodeTagged(e *SExpr) (err error) {
+	// skip '@':
+	d.i++
+
+	if d.i >= len(d.b) {
+		err = io.ErrUnexpectedEOF
+		return
+	}
+	if !isSymbolStart(d.b[d.i]) {
+		err = ErrUnexpectedCharacter
+		return
+	}
+
+	var tag SExprPrimitive
+	err = d.decodeIdentifier(&tag)
+	if err != nil {
+		return
+	}
+	if tag.kind != KindSymbol {
+		err = ErrInvalidTag
+		return
+	}
+
+	if len(d.b)-d.i < 2 {
+		err = io.ErrUnexpectedEOF
+		return
+	}
+	if d.b[d.i] != '(' || d.b[d.i+1] == ')' || d.b[d.i+1] == ' ' {
+		err = ErrUnexpectedCharacter
+		return
+	}
+	d.i++
+
+	inner := &SExpr{}
+	err = d.decodeNode(inner)
+	if err != nil {
+		return
+	}
+	inner = d.shared(inner)
+
+	if d.i >= len(d.b) {
+		err = io.ErrUnexpectedEOF
+		return
+	}
+	if d.b[d.i] != ')' {
+		err = ErrUnexpectedCharacter
+		return
+	}
+	d.i++
+
+	err = setTagged(e, nil, tag.octets, inner)
+	return
+}
+
+func (d *bytesDecoder) decodeLabel(e *SExpr) (err error) {
+	// skip '&':
+	d.i++
+
+	var n uint64
+	n, err = d.decodeHex(math.MaxUint64)
+	if err != nil {
+		return
+	}
+
+	// the labeled expression must follow '=' without whitespace:
+	if len(d.b)-d.i < 2 {
+		err = io.ErrUnexpectedEOF
+		return
+	}
+	if c := d.b[d.i+1]; d.b[d.i] != '=' || c == ' ' || c == ')' || c == '}' {
+		err = ErrUnexpectedCharacter
+		return
+	}
+	d.i++
+
+	d.labels, err = defineLabel(d.labels, n)
+	if err != nil {
+		return
+	}
+	i := len(d.labels) - 1
+
+	err = d.decodeNode(e)
+	if err != nil {
+		return
+	}
+
+	// a label may also be given to a reference:
+	if d.ref != nil {
+		e = d.ref
+	}
+	d.labels[i].e = e
+	return
+}
+
+func (d *bytesDecoder) decodeReference() (err error) {
+	// skip '*':
+	d.i++
+
+	var n uint64
+	n, err = d.decodeHex(math.MaxUint64)
+	if err != nil {
+		return
+	}
+
+	d.ref, err = resolveLabel(d.labels, n)
+	return
+}
+
+func (d *bytesDecoder) decodeIdentifier(p *SExprPrimitive) (err error) {
+	s := d.i
+	for d.i++; d.i < len(d.b); d.i++ {
+		if !isSymbolChar(d.b[d.i]) {
+			break
+		}
+	}
+
+	switch ident := d.b[s:d.i]; string(ident) {
+	case "nil":
+		p.SetNil()
+	case "true":
+		p.SetBool(true)
+	case "false":
+		p.SetBool(false)
+	default:
+		if d.alias {
+			p.SetSymbol(bytesToString(ident))
+		} else {
+			p.SetSymbol(string(ident))
+		}
+	}
+	return
+}
+
+// decodeHex parses at least one hex digit as an unsigned value no greater than max.
+func (d *bytesDecoder) decodeHex(max uint64) (v uint64, err error) {
+	s := d.i
+	for ; d.i < len(d.b); d.i++ {
+		x := unhex[d.b[d.i]]
+		if x == 0xff {
+			break
+		}
+
+		if v > (max-uint64(x))>>4 {
+			err = strconv.ErrRange
+			return
+		}
+		v = v<<4 | uint64(x)
+	}
+
+	if d.i == s {
+		if d.i >= len(d.b) {
+			err = io.ErrUnexpectedEOF
+		} else {
+			err = ErrUnexpectedCharacter
+		}
+		return
+	}
+
+	return
+}
+
+// decodeFloat decodes exactly 16 hex digits of IEEE 754 bits.
+func (d *bytesDecoder) decodeFloat() (b int64, err error) {
+	if len(d.b)-d.i < 17 {
+		// a terminating character must follow:
+		err = io.ErrUnexpectedEOF
+		return
+	}
+
+	var v uint64
+	for j := 0; j < 16; j++ {
+		x := unhex[d.b[d.i]]
+		if x == 0xff {
+			err = ErrUnexpectedCharacter
+			return
+		}
+		v = v<<4 | uint64(x)
+		d.i++
+	}
+	if unhex[d.b[d.i]] != 0xff {
+		err = ErrUnexpectedCharacter
+		return
+	}
+
+	if !isCanonicalFloatBits(v) {
+		err = ErrNonCanonicalFloat
+		return
+	}
+
+	b = int64(v)
+	return
+}
+
+func (d *bytesDecoder) decodeHexOctets() (v string, err error) {
+	var size uint64
+	size, err = d.decodeHex(1<<63 - 1)
+	if err != nil {
+		return
+	}
+
+	if d.i >= len(d.b) {
+		err = io.ErrUnexpectedEOF
+		return
+	}
+	if d.b[d.i] != '$' {
+		err = ErrUnexpectedCharacter
+		return
+	}
+	d.i++
+
+	if uint64(len(d.b)-d.i)/2 < size {
+		err = io.ErrUnexpectedEOF
+		return
+	}
+
+	n := int(size)
+	src := d.b[d.i : d.i+n*2]
+	var dst []byte
+	if d.alias {
+		// decode in place; each octet is written no later than its hex digits are read:
+		dst = src[:n]
+	} else {
+		dst = make([]byte, n)
+	}
+
+	for j := 0; j < n; j++ {
+		hi, lo := unhex[src[j*2]], unhex[src[j*2+1]]
+		if hi == 0xff || lo == 0xff {
+			d.i += j * 2
+			err = ErrUnexpectedCharacter
+			return
+		}
+		dst[j] = hi<<4 | lo
+	}
+
+	d.i += n * 2
+	v = bytesToString(dst)
+	return
+}
+
+func (d *bytesDecoder) decodeString() (v string, err error) {
+	s := d.i
+
+	// fast path for strings without escapes:
+	for ; d.i < len(d.b); d.i++ {
+		c := d.b[d.i]
+		if c == '"' {
+			if d.alias {
+				v = bytesToString(d.b[s:d.i])
+			} else {
+				v = string(d.b[s:d.i])
+			}
+			d.i++
+			return
+		}
+		if c == '\\' {
+			break
+		}
+		if c == '\r' || c == '\n' {
+			err = ErrUnexpectedCharacter
+			return
+		}
+	}
+	if d.i >= len(d.b) {
+		err = io.ErrUnexpectedEOF
+		return
+	}
+
+	// unescape the remainder:
+	var dst []byte
+	if d.alias {
+		// unescape in place; escapes are always longer than the characters they produce:
+		dst = d.b[s:d.i]
+	} else {
+		dst = make([]byte, d.i-s, d.i-s+16)
+		copy(dst, d.b[s:d.i])
+	}
+
+	for d.i < len(d.b) {
+		c := d.b[d.i]
+		d.i++
+
+		if c == '"' {
+			v = bytesToString(dst)
+			return
+		}
+		if c == '\r' || c == '\n' {
+			err = ErrUnexpectedCharacter
+			return
+		}
+		if c != '\\' {
+			dst = append(dst, c)
+			continue
+		}
+
+		if d.i >= len(d.b) {
+			break
+		}
+		c = d.b[d.i]
+		d.i++
+
+		switch c {
+		case '\\', '"':
+			dst = append(dst, c)
+		case 'r':
+			dst = append(dst, '\r')
+		case 'n':
+			dst = append(dst, '\n')
+		case 't':
+			dst = append(dst, '\t')
+		case 'x':
+			if len(d.b)-d.i < 2 {
+				err = io.ErrUnexpectedEOF
+				return
+			}
+			hi, lo := unhex[d.b[d.i]], unhex[d.b[d.i+1]]
+			if hi == 0xff || lo == 0xff {
+				err = ErrUnexpectedCharacter
+				return
+			}
+			d.i += 2
+			dst = append(dst, hi<<4|lo)
+		default:
+			// unknown escapes are dropped like Decoder does:
+		}
+	}
+
+	err = io.ErrUnexpectedEOF
+	return
+}
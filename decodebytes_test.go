@@ -0,0 +1,143 @@
+package brass
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func TestDecodeBytes(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		wantN   int
+		wantErr bool
+	}{
+		{name: "()", s: "()", wantN: 2},
+		{name: "(()()) trailing", s: "(()()) ($1)", wantN: 6},
+		{name: "($3ff -$3ff $0)", s: "($3ff -$3ff $0)", wantN: 15},
+		{name: "($7fffffffffffffff -$8000000000000000)", s: "($7fffffffffffffff -$8000000000000000)", wantN: 38},
+		{name: "(nil true false)", s: "(nil true false)", wantN: 16},
+		{name: "(#0$ #5$fffefdfcfb)", s: "(#0$ #5$fffefdfcfb)", wantN: 19},
+		{name: `("" "abc")`, s: `("" "abc")`, wantN: 10},
+		{name: `("abc\r\n\t\xff123\\[]\"x")`, s: `("abc\r\n\t\xff123\\[]\"x")`, wantN: 27},
+		{name: `({("abc" $1) (#1$00 ($2 {}))})`, s: `({("abc" $1) (#1$00 ($2 {}))})`, wantN: 30},
+		{name: "(", s: "(", wantErr: true},
+		{name: "($)", s: "($)", wantErr: true},
+		{name: "($8000000000000000)", s: "($8000000000000000)", wantErr: true},
+		{name: "(#3$0102)", s: "(#3$0102)", wantErr: true},
+		{name: "(#2$01g2)", s: "(#2$01g2)", wantErr: true},
+		{name: `("a\qb")`, s: `("a\qb")`, wantN: 8},
+		{name: "(\"abc\ndef\")", s: "(\"abc\ndef\")", wantErr: true},
+		{name: "({(() $1)})", s: "({(() $1)})", wantErr: true},
+		{name: "(read write-mem a.b/c_D9 nilx)", s: "(read write-mem a.b/c_D9 nilx)", wantN: 30},
+		{name: "({(read $1)} sym\"a\")", s: "({(read $1)} sym\"a\")", wantN: 20},
+		{name: "floats", s: "(%3ff0000000000000 %8000000000000000 %7ff0000000000000 %fff0000000000000 %7ff8000000000000)", wantN: 91},
+		{name: "({(%4000000000000000 $1)})", s: "({(%4000000000000000 $1)})", wantN: 26},
+		{name: "(%3ff000000000000)", s: "(%3ff000000000000)", wantErr: true},
+		{name: "(%3ff00000000000000)", s: "(%3ff00000000000000)", wantErr: true},
+		{name: "(%3FF0000000000000)", s: "(%3FF0000000000000)", wantErr: true},
+		{name: "(%7ff8000000000001)", s: "(%7ff8000000000001)", wantErr: true},
+		{name: "(@uuid(#2$0102) @p(($1)))", s: "(@uuid(#2$0102) @p(($1)))", wantN: 25},
+		{name: "(@p($1 ))", s: "(@p($1 ))", wantErr: true},
+		{name: "(@p())", s: "(@p())", wantErr: true},
+		{name: "(@p( $1))", s: "(@p( $1))", wantErr: true},
+		{name: "(@p $1)", s: "(@p $1)", wantErr: true},
+		{name: "(9ab)", s: "(9ab)", wantErr: true},
+		{name: "(-abc)", s: "(-abc)", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wantE, wantErr := NewDecoder(bytes.NewBufferString(tt.s)).Decode()
+			if (wantErr != nil) != tt.wantErr {
+				t.Fatalf("Decode() error = %v, wantErr %v", wantErr, tt.wantErr)
+			}
+
+			gotE, gotN, err := DecodeBytes([]byte(tt.s))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DecodeBytes() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if gotN != tt.wantN {
+				t.Errorf("DecodeBytes() gotN = %v, want %v", gotN, tt.wantN)
+			}
+			if !reflect.DeepEqual(gotE, wantE) {
+				t.Errorf("DecodeBytes() gotE = %v, want %v", gotE, wantE)
+			}
+
+			b := []byte(tt.s)
+			gotE, gotN, err = DecodeBytesAliased(b)
+			if err != nil {
+				t.Fatalf("DecodeBytesAliased() error = %v", err)
+			}
+			if gotN != tt.wantN {
+				t.Errorf("DecodeBytesAliased() gotN = %v, want %v", gotN, tt.wantN)
+			}
+			if !reflect.DeepEqual(gotE, wantE) {
+				t.Errorf("DecodeBytesAliased() gotE = %v, want %v", gotE, wantE)
+			}
+		})
+	}
+}
+
+func benchmarkMessages() map[string][]byte {
+	dump := make([]byte, 32768)
+	rand.Read(dump)
+
+	return map[string][]byte{
+		"small": []byte(`("read" $7e0010 $10 {("tag" "abc\tdef") ("ok" true)})`),
+		"dump":  []byte(fmt.Sprintf(`("wram" $7e0000 #%x$%s)`, len(dump), hex.EncodeToString(dump))),
+	}
+}
+
+func BenchmarkDecoder_Decode(b *testing.B) {
+	for name, msg := range benchmarkMessages() {
+		b.Run(name, func(b *testing.B) {
+			b.SetBytes(int64(len(msg)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				_, err := NewDecoder(bytes.NewReader(msg)).Decode()
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkDecodeBytes(b *testing.B) {
+	for name, msg := range benchmarkMessages() {
+		b.Run(name, func(b *testing.B) {
+			b.SetBytes(int64(len(msg)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				_, _, err := DecodeBytes(msg)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkDecodeBytesAliased(b *testing.B) {
+	for name, msg := range benchmarkMessages() {
+		b.Run(name, func(b *testing.B) {
+			buf := make([]byte, len(msg))
+			b.SetBytes(int64(len(msg)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				copy(buf, msg)
+				_, _, err := DecodeBytesAliased(buf)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
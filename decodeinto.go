@@ -0,0 +1,444 @@
+package brass
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// DecodeInto decodes the next top-level list directly into the Go value pointed to by v, as Unmarshal would
+// after Decode, but without building an intermediate tree for lists, maps and primitives. Map entries without a
+// corresponding struct field are skipped over without being decoded. Values whose types implement
+// BrassUnmarshaler, have a registered tag or are interfaces are decoded as trees first and then converted with
+// Unmarshal, as are labeled expressions and references. Labels defined within skipped entries cannot be referred
+// to. Tags must be registered before a type is first decoded since decoding plans are cached per type.
+func (d *Decoder) DecodeInto(v any) (err error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return ErrInvalidUnmarshal
+	}
+
+	d.clearLabels()
+	defer d.clearLabels()
+	d.expanded = 0
+
+	var c byte
+	c, err = d.s.ReadByte()
+	if err != nil {
+		return
+	}
+	if c != '(' {
+		err = ErrUnexpectedCharacter
+		return
+	}
+	err = d.s.UnreadByte()
+	if err != nil {
+		return
+	}
+
+	rv = rv.Elem()
+	return typeDecoder(rv.Type())(d, make(Path, 0, 8), rv)
+}
+
+// decodeFunc decodes the next s-expression into v.
+type decodeFunc func(d *Decoder, path Path, v reflect.Value) error
+
+var typeDecoderCache sync.Map
+
+// typeDecoder returns the cached decoding plan for a type.
+func typeDecoder(t reflect.Type) decodeFunc {
+	if f, ok := typeDecoderCache.Load(t); ok {
+		return f.(decodeFunc)
+	}
+
+	// recursive types refer to themselves through an indirect function until their plan is complete:
+	var wg sync.WaitGroup
+	var f decodeFunc
+	wg.Add(1)
+	fi, loaded := typeDecoderCache.LoadOrStore(t, decodeFunc(func(d *Decoder, path Path, v reflect.Value) error {
+		wg.Wait()
+		return f(d, path, v)
+	}))
+	if loaded {
+		return fi.(decodeFunc)
+	}
+
+	f = newTypeDecoder(t)
+	wg.Done()
+	typeDecoderCache.Store(t, f)
+	return f
+}
+
+func newTypeDecoder(t reflect.Type) decodeFunc {
+	if t == sexprType || t.Implements(unmarshalerType) || reflect.PointerTo(t).Implements(unmarshalerType) {
+		return decodeTree
+	}
+	if _, _, ok := DefaultTagRegistry.lookupType(t); ok {
+		return decodeTree
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return newPointerDecoder(t)
+	case reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64,
+		reflect.String:
+		return decodePrimitiveInto
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return decodePrimitiveInto
+		}
+		return newSliceDecoder(t)
+	case reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return decodePrimitiveInto
+		}
+		return newArrayDecoder(t)
+	case reflect.Map:
+		return newMapDecoder(t)
+	case reflect.Struct:
+		return newStructDecoder(t)
+	default:
+		return decodeTree
+	}
+}
+
+// peek skips whitespace and returns the next character without consuming it.
+func (d *Decoder) peek() (c byte, err error) {
+	for {
+		c, err = d.s.ReadByte()
+		if err != nil {
+			return
+		}
+		if c != ' ' {
+			break
+		}
+	}
+
+	err = d.s.UnreadByte()
+	return
+}
+
+// expect consumes the next character which must be c.
+func (d *Decoder) expect(c byte) (err error) {
+	var x byte
+	x, err = d.s.ReadByte()
+	if err != nil {
+		return
+	}
+	if x != c {
+		err = ErrUnexpectedCharacter
+	}
+	return
+}
+
+// decodeTree decodes the next s-expression as a tree and converts it into v with Unmarshal.
+func decodeTree(d *Decoder, path Path, v reflect.Value) (err error) {
+	c, err := d.peek()
+	if err != nil {
+		return
+	}
+	if c == ')' || c == '}' {
+		return atPath(path, ErrUnexpectedCharacter)
+	}
+
+	e := &SExpr{}
+	err = d.decodeNode(e, e)
+	if err != nil {
+		return atPath(path, err)
+	}
+	e = d.shared(e)
+
+	if len(d.labels) > 0 {
+		max := d.maxExpansion
+		if max <= 0 {
+			max = DefaultMaxExpansion
+		}
+		d.expanded += expansionOf(e, d.labels, max-d.expanded)
+		if d.expanded > max {
+			return ErrExpansionLimit
+		}
+	}
+
+	return unmarshal(path, e, v)
+}
+
+// isPrimitiveStart returns true if c may start a primitive atom.
+func isPrimitiveStart(c byte) bool {
+	switch c {
+	case '(', '{', '@', '&', '*', ')', '}':
+		return false
+	default:
+		return true
+	}
+}
+
+func decodePrimitiveInto(d *Decoder, path Path, v reflect.Value) (err error) {
+	c, err := d.peek()
+	if err != nil {
+		return
+	}
+	if !isPrimitiveStart(c) {
+		return decodeTree(d, path, v)
+	}
+
+	var p SExprPrimitive
+	err = d.decodeNode(&p, nil)
+	if err != nil {
+		return atPath(path, err)
+	}
+
+	e := SExpr{kind: p.kind, integer: p.integer, octets: p.octets}
+	return unmarshal(path, &e, v)
+}
+
+func newPointerDecoder(t reflect.Type) decodeFunc {
+	elem := typeDecoder(t.Elem())
+	return func(d *Decoder, path Path, v reflect.Value) (err error) {
+		c, err := d.peek()
+		if err != nil {
+			return
+		}
+		if isSymbolStart(c) || !isPrimitiveStart(c) && c != '(' && c != '{' {
+			// nil, references and the like:
+			return decodeTree(d, path, v)
+		}
+
+		if v.IsNil() {
+			v.Set(reflect.New(t.Elem()))
+		}
+		return elem(d, path, v.Elem())
+	}
+}
+
+// decodeElements decodes the elements of a list, calling elem for each index with the element yet to be decoded.
+func (d *Decoder) decodeElements(elem func(i int) error) (err error) {
+	err = d.expect('(')
+	if err != nil {
+		return
+	}
+
+	d.depth++
+	for i := 0; ; i++ {
+		var c byte
+		c, err = d.peek()
+		if err != nil {
+			break
+		}
+		if c == ')' {
+			_, _ = d.s.ReadByte()
+			break
+		}
+
+		err = elem(i)
+		if err != nil {
+			break
+		}
+	}
+	d.depth--
+	return
+}
+
+func newSliceDecoder(t reflect.Type) decodeFunc {
+	elem := typeDecoder(t.Elem())
+	return func(d *Decoder, path Path, v reflect.Value) (err error) {
+		c, err := d.peek()
+		if err != nil {
+			return
+		}
+		if c != '(' {
+			return decodeTree(d, path, v)
+		}
+
+		// a new backing array is allocated as Unmarshal does:
+		s := reflect.MakeSlice(t, 0, 0)
+		err = d.decodeElements(func(i int) error {
+			s = reflect.Append(s, reflect.Zero(t.Elem()))
+			return elem(d, append(path, PathElem{Index: i}), s.Index(i))
+		})
+		if err != nil {
+			return
+		}
+
+		v.Set(s)
+		return
+	}
+}
+
+func newArrayDecoder(t reflect.Type) decodeFunc {
+	elem := typeDecoder(t.Elem())
+	return func(d *Decoder, path Path, v reflect.Value) (err error) {
+		c, err := d.peek()
+		if err != nil {
+			return
+		}
+		if c != '(' {
+			return decodeTree(d, path, v)
+		}
+
+		n := 0
+		err = d.decodeElements(func(i int) error {
+			if i >= v.Len() {
+				return atPath(path, ErrUnrepresentable)
+			}
+			n++
+			return elem(d, append(path, PathElem{Index: i}), v.Index(i))
+		})
+		if err != nil {
+			return
+		}
+
+		for i := n; i < v.Len(); i++ {
+			v.Index(i).Set(reflect.Zero(t.Elem()))
+		}
+		return
+	}
+}
+
+// decodeEntries decodes the entries of a map, calling entry for each key with the value yet to be decoded.
+func (d *Decoder) decodeEntries(path Path, entry func(key *SExprPrimitive) error) (err error) {
+	err = d.expect('{')
+	if err != nil {
+		return
+	}
+
+	d.depth++
+	for {
+		var c byte
+		c, err = d.peek()
+		if err != nil {
+			break
+		}
+		if c == '}' {
+			_, _ = d.s.ReadByte()
+			break
+		}
+
+		err = d.expect('(')
+		if err != nil {
+			break
+		}
+
+		var key SExprPrimitive
+		err = d.decodeNode(&key, nil)
+		if err != nil {
+			err = atPath(path, err)
+			break
+		}
+
+		err = entry(&key)
+		if err != nil {
+			break
+		}
+
+		err = d.expect(')')
+		if err != nil {
+			break
+		}
+	}
+	d.depth--
+	return
+}
+
+// skipValue skips over the next s-expression without decoding it.
+func (d *Decoder) skipValue() (err error) {
+	d.raw = d.raw[:0]
+	d.rawLabels = len(d.labels)
+	err = d.skipNode()
+	d.raw = d.raw[:0]
+	return
+}
+
+func newMapDecoder(t reflect.Type) decodeFunc {
+	elem := typeDecoder(t.Elem())
+	return func(d *Decoder, path Path, v reflect.Value) (err error) {
+		c, err := d.peek()
+		if err != nil {
+			return
+		}
+		if c != '{' {
+			return decodeTree(d, path, v)
+		}
+
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(t))
+		}
+		return d.decodeEntries(path, func(p *SExprPrimitive) (err error) {
+			elemPath := append(path, PathElem{IsKey: true, Key: *p})
+
+			k := reflect.New(t.Key()).Elem()
+			e := SExpr{kind: p.kind, integer: p.integer, octets: p.octets}
+			err = unmarshal(elemPath, &e, k)
+			if err != nil {
+				return
+			}
+
+			x := reflect.New(t.Elem()).Elem()
+			err = elem(d, elemPath, x)
+			if err != nil {
+				return
+			}
+
+			v.SetMapIndex(k, x)
+			return
+		})
+	}
+}
+
+func newStructDecoder(t reflect.Type) decodeFunc {
+	info := cachedStructInfo(t)
+	fields := make([]decodeFunc, len(info.fields))
+	for i := range info.fields {
+		fields[i] = typeDecoder(t.FieldByIndex(info.fields[i].index).Type)
+	}
+
+	if info.tuple {
+		return newTupleDecoder(t, info, fields)
+	}
+
+	return func(d *Decoder, path Path, v reflect.Value) (err error) {
+		c, err := d.peek()
+		if err != nil {
+			return
+		}
+		if c != '{' {
+			return decodeTree(d, path, v)
+		}
+
+		return d.decodeEntries(path, func(p *SExprPrimitive) (err error) {
+			i, ok := info.byName[p.octets]
+			if p.kind != KindString || !ok {
+				// unknown keys are skipped over:
+				err = d.skipValue()
+				if err != nil {
+					err = atPath(append(path, PathElem{IsKey: true, Key: *p}), err)
+				}
+				return
+			}
+
+			return fields[i](d, append(path, PathElem{IsKey: true, Key: *p}), v.FieldByIndex(info.fields[i].index))
+		})
+	}
+}
+
+// newTupleDecoder decodes the elements of a list into the fields of a tuple struct in order.
+func newTupleDecoder(t reflect.Type, info *structInfo, fields []decodeFunc) decodeFunc {
+	return func(d *Decoder, path Path, v reflect.Value) (err error) {
+		c, err := d.peek()
+		if err != nil {
+			return
+		}
+		if c != '(' {
+			return decodeTree(d, path, v)
+		}
+
+		return d.decodeElements(func(i int) error {
+			if i >= len(fields) {
+				return atPath(path, fmt.Errorf("%w: too many fields for %v", ErrUnrepresentable, t))
+			}
+			return fields[i](d, append(path, PathElem{Index: i}), v.FieldByIndex(info.fields[i].index))
+		})
+	}
+}
@@ -0,0 +1,247 @@
+package brass
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type frame struct {
+	Seq     uint32            `brass:"seq"`
+	Players []player          `brass:"players"`
+	Tiles   []byte            `brass:"tiles"`
+	Flags   map[string]bool   `brass:"flags"`
+	Rooms   map[int64][2]int8 `brass:"rooms"`
+	Parent  *frame            `brass:"parent"`
+	Note    *string           `brass:"note"`
+}
+
+type player struct {
+	Name string   `brass:"name"`
+	Pos  position `brass:"pos"`
+	HP   float64  `brass:"hp"`
+	Tags []Symbol `brass:"tags"`
+	Vel  velocity `brass:"vel"`
+}
+
+// velocity is encoded positionally as (dx dy [scale]).
+type velocity struct {
+	_     struct{} `brass:",tuple"`
+	DX    int
+	DY    int
+	Scale float32 `brass:",omitempty"`
+}
+
+func TestDecoder_DecodeInto(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+	}{
+		{
+			name: "frames",
+			s: `({("seq" $1) ("players" ({("name" "link") ("pos" ($1 $2)) ("hp" %4008000000000000) ("tags" (hero)) ("vel" ($1 -$1))}))` +
+				` ("tiles" #2$0102) ("flags" {("a" true)}) ("rooms" {($1 ($1 -$1))}) ("note" "x")}` +
+				` {("seq" $2) ("parent" {("seq" $1)}) ("unknown" {("x" ($1 "a" @t($1)))}) ("players" ())})`,
+		},
+		{
+			name: "integer into float",
+			s:    `({("players" ({("hp" $3)}))})`,
+		},
+		{
+			name: "nil",
+			s:    `({("players" nil) ("parent" nil) ("flags" nil) ("note" nil)} nil)`,
+		},
+		{
+			name: "tuple",
+			s:    `({("players" ({("vel" ($2 $3 %3fe0000000000000))} {("vel" ())} {("vel" nil)}))})`,
+		},
+		{
+			name: "references",
+			s:    `({("players" (&0={("name" "a")} *0 *0))} {("parent" &1={("seq" $1)})} {("parent" *1)})`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []frame
+			err := NewDecoder(strings.NewReader(tt.s)).DecodeInto(&got)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// must agree with decoding a tree then unmarshaling it:
+			e, err := NewDecoder(strings.NewReader(tt.s)).Decode()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var want []frame
+			if err = Unmarshal(e, &want); err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("DecodeInto() = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestDecoder_DecodeIntoErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		wantErr error
+		wantMsg string
+	}{
+		{
+			name:    "wrong kind",
+			s:       `({("players" ({("name" $1)}))})`,
+			wantErr: ErrWrongKind,
+			wantMsg: `[0]["players"][0]["name"]: wrong kind: cannot unmarshal KindInteger into string`,
+		},
+		{
+			name:    "list into struct",
+			s:       `(($1))`,
+			wantErr: ErrWrongKind,
+			wantMsg: `[0]: wrong kind: cannot unmarshal KindList into brass.frame`,
+		},
+		{
+			name:    "tuple fields",
+			s:       `({("players" ({("vel" ($1 $2 $3 $4))}))})`,
+			wantErr: ErrUnrepresentable,
+			wantMsg: `[0]["players"][0]["vel"]: value cannot be represented: too many fields for brass.velocity`,
+		},
+		{
+			name:    "map into tuple",
+			s:       `({("players" ({("vel" {("DX" $1)})}))})`,
+			wantErr: ErrWrongKind,
+			wantMsg: `[0]["players"][0]["vel"]: wrong kind: cannot unmarshal KindMap into brass.velocity`,
+		},
+		{
+			name:    "overflow",
+			s:       `({("seq" -$1)})`,
+			wantErr: ErrUnrepresentable,
+		},
+		{
+			name:    "invalid skipped entry",
+			s:       `({("unknown" ($1 "a\xq0"))})`,
+			wantErr: ErrUnexpectedCharacter,
+		},
+		{
+			name:    "unmarshaler error",
+			s:       `({("players" ({("pos" ($1))}))})`,
+			wantMsg: `[0]["players"][0]["pos"]: expected 2 coordinates, got 1`,
+		},
+		{
+			name:    "array length",
+			s:       `({("rooms" {($1 ($1 $2 $3))})})`,
+			wantErr: ErrUnrepresentable,
+		},
+		{
+			name:    "reference into skipped entry",
+			s:       `({("unknown" &0=($1))} {("parent" *0)})`,
+			wantErr: ErrUndefinedReference,
+		},
+		{
+			name:    "not a list",
+			s:       `{("seq" $1)}`,
+			wantErr: ErrUnexpectedCharacter,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []frame
+			err := NewDecoder(strings.NewReader(tt.s)).DecodeInto(&got)
+			if err == nil {
+				t.Fatal("DecodeInto() expected error")
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("DecodeInto() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantMsg != "" && err.Error() != tt.wantMsg {
+				t.Errorf("DecodeInto() error = %q, want %q", err, tt.wantMsg)
+			}
+		})
+	}
+}
+
+func TestDecoder_DecodeIntoExpansionLimit(t *testing.T) {
+	d := NewDecoder(strings.NewReader(`(&0=($1 $2) *0 *0 *0)`))
+	d.SetMaxExpansion(8)
+	var got [][]int
+	if err := d.DecodeInto(&got); !errors.Is(err, ErrExpansionLimit) {
+		t.Errorf("DecodeInto() error = %v, want %v", err, ErrExpansionLimit)
+	}
+}
+
+func TestDecoder_DecodeIntoSlices(t *testing.T) {
+	// existing backing arrays are not reused, as with Unmarshal:
+	backing := []int{7, 8, 9}
+	got := backing[:1]
+	if err := NewDecoder(strings.NewReader(`($1 $2)`)).DecodeInto(&got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, []int{1, 2}) {
+		t.Errorf("DecodeInto() = %v", got)
+	}
+	if !reflect.DeepEqual(backing, []int{7, 8, 9}) {
+		t.Errorf("DecodeInto() modified existing backing array = %v", backing)
+	}
+}
+
+func TestDecoder_DecodeIntoDeferNested(t *testing.T) {
+	s := `(($1) {("a" ($2))} ($3 ($4)))`
+
+	// nesting depth is tracked as with Decode:
+	var into []*SExpr
+	d := NewDecoder(strings.NewReader(s))
+	d.DeferNested(2)
+	if err := d.DecodeInto(&into); err != nil {
+		t.Fatal(err)
+	}
+
+	d = NewDecoder(strings.NewReader(s))
+	d.DeferNested(2)
+	e, err := d.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := e.AsList()
+	if !reflect.DeepEqual(into, want) {
+		t.Errorf("DecodeInto() = %v, want %v", into, want)
+	}
+}
+
+func BenchmarkDecoder_DecodeInto(b *testing.B) {
+	s := `({("seq" $1) ("players" ({("name" "link") ("hp" %4008000000000000) ("tags" (hero))}` +
+		` {("name" "zelda") ("hp" %4010000000000000) ("tags" (npc))})) ("tiles" #4$00010203) ("flags" {("a" true)})})`
+	r := strings.NewReader(s)
+	d := NewDecoder(r)
+
+	b.Run("DecodeInto", func(b *testing.B) {
+		b.ReportAllocs()
+		var v []frame
+		for i := 0; i < b.N; i++ {
+			r.Reset(s)
+			d.Reset(r)
+			if err := d.DecodeInto(&v); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("Decode+Unmarshal", func(b *testing.B) {
+		b.ReportAllocs()
+		var v []frame
+		for i := 0; i < b.N; i++ {
+			r.Reset(s)
+			d.Reset(r)
+			e, err := d.Decode()
+			if err != nil {
+				b.Fatal(err)
+			}
+			if err = Unmarshal(e, &v); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
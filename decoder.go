@@ -4,23 +4,136 @@ import (
 	"bytes"
 	"errors"
 	"io"
+	"math"
 	"strconv"
 )
 
 var ErrUnexpectedCharacter = errors.New("unexpected character")
 var ErrNotPrimitive = errors.New("unexpected primitive type")
+var ErrNonCanonicalFloat = errors.New("non-canonical NaN float encoding")
 
 type Decoder struct {
 	s io.ByteScanner
+
+	// depth is the number of lists and maps currently being decoded:
+	depth int
+	// deferDepth is the depth at which nested lists and maps are captured as KindRaw instead of decoded:
+	deferDepth int
+	// orderedMaps decodes maps as OrderedMaps:
+	orderedMaps bool
+	// tags converts tagged values; nil selects DefaultTagRegistry:
+	tags *TagRegistry
+	// raw records the bytes consumed while skipping over an s-expression:
+	raw []byte
+	// labels holds the expressions of labels defined so far in the current expression:
+	labels []label
+	// rawLabels is the number of labels defined before the raw expression currently being skipped:
+	rawLabels int
+	// ref is used in place of the decoded node, e.g. the labeled expression most recently referred to:
+	ref *SExpr
+	// octetsHandler streams octets atoms of at least octetsMin octets:
+	octetsHandler OctetsHandler
+	octetsMin     int64
+	// maxExpansion limits the size of a decoded tree with references expanded; 0 selects DefaultMaxExpansion:
+	maxExpansion int
+	// expanded counts the nodes converted from references by DecodeInto so far:
+	expanded int
+
+	// buf is reused for decoding strings and octets:
+	buf bytes.Buffer
+	// free holds released nodes for reuse by subsequent decodes:
+	free []*SExpr
 }
 
 func NewDecoder(s io.ByteScanner) *Decoder {
 	return &Decoder{s: s}
 }
 
+// Reset discards any decoder state and switches to reading from s so that the decoder along with its released
+// nodes and internal buffers can be reused. Options such as DeferNested are retained.
+func (d *Decoder) Reset(s io.ByteScanner) {
+	d.s = s
+	d.depth = 0
+	d.raw = d.raw[:0]
+	d.clearLabels()
+	d.buf.Reset()
+}
+
+// Release returns all nodes of the tree rooted at e to the decoder so that subsequent calls to Decode may reuse
+// them along with their list and map storage. Neither e nor any node, list or map obtained from it may be used
+// after it is released. Trees containing nodes not produced by this decoder must not be released. Nodes shared
+// through references are released only once.
+func (d *Decoder) Release(e *SExpr) {
+	if e == nil || e.kind == kindReleased {
+		return
+	}
+
+	// children are released before their parent in reverse order so that nodes are reused in the same order
+	// they are allocated in while decoding a similarly shaped tree:
+	switch e.kind {
+	case KindList:
+		for i := len(e.list) - 1; i >= 0; i-- {
+			d.Release(e.list[i])
+			e.list[i] = nil
+		}
+		e.list = e.list[:0]
+	case KindTagged:
+		d.Release(e.tagged)
+		e.tagged = nil
+		e.value = nil
+	case KindMap:
+		if e.omap != nil {
+			for i := len(e.omap.entries) - 1; i >= 0; i-- {
+				d.Release(e.omap.entries[i].Value)
+			}
+			e.omap.clear()
+			break
+		}
+		for k, v := range e.dict {
+			d.Release(v)
+			delete(e.dict, k)
+		}
+	}
+
+	e.kind = kindReleased
+	d.free = append(d.free, e)
+}
+
+// alloc returns a released node reset to nil if one is available or else a new node. Released nodes retain their
+// list and map storage for reuse by decodeList and decodeMap.
+func (d *Decoder) alloc() (e *SExpr) {
+	n := len(d.free)
+	if n == 0 {
+		return &SExpr{}
+	}
+
+	e = d.free[n-1]
+	d.free[n-1] = nil
+	d.free = d.free[:n-1]
+	*e = SExpr{list: e.list, dict: e.dict, omap: e.omap}
+	return
+}
+
+// DeferNested instructs the decoder to capture lists and maps nested at or deeper than the given depth as
+// KindRaw expressions instead of decoding them. The top-level list is at depth 0 so a depth of 1 decodes only
+// the elements of the top-level list and defers all of its nested lists and maps. A depth of 0 disables deferral.
+func (d *Decoder) DeferNested(depth int) {
+	d.deferDepth = depth
+}
+
 func (d *Decoder) Decode() (e *SExpr, err error) {
-	e = &SExpr{}
+	d.clearLabels()
+	defer d.clearLabels()
+
+	e = d.alloc()
 	err = d.decodeList(e)
+	if err != nil {
+		return
+	}
+
+	if len(d.labels) > 0 {
+		err = checkExpansion(e, d.labels, d.maxExpansion)
+	}
 	return
 }
 
@@ -36,12 +149,20 @@ func (d *Decoder) decodeList(e *SExpr) (err error) {
 		return
 	}
 
+	d.depth++
+
+	// reuse list storage of released nodes:
+	list := e.list[:0]
 	e.reset()
 	e.kind = KindList
-	e.list = make([]*SExpr, 0, 10)
+	if cap(list) == 0 {
+		list = make([]*SExpr, 0, 10)
+	}
+	e.list = list
 	for {
 		c, err = d.s.ReadByte()
 		if err != nil {
+			d.depth--
 			return
 		}
 		if c == ' ' {
@@ -49,22 +170,23 @@ func (d *Decoder) decodeList(e *SExpr) (err error) {
 		}
 
 		if c == ')' {
+			d.depth--
 			return
 		}
 
 		err = d.s.UnreadByte()
 		if err != nil {
+			d.depth--
 			return
 		}
 
-		var child *SExpr = &SExpr{}
+		var child *SExpr = d.alloc()
 		err = d.decodeNode(child, child)
 		if err != nil {
+			d.depth--
 			return
 		}
-		if child == nil {
-			continue
-		}
+		child = d.shared(child)
 
 		e.list = append(e.list, child)
 	}
@@ -82,12 +204,27 @@ func (d *Decoder) decodeMap(e *SExpr) (err error) {
 		return
 	}
 
+	d.depth++
+
+	// reuse map storage of released nodes:
+	dict, omap := e.dict, e.omap
 	e.reset()
 	e.kind = KindMap
-	e.dict = make(map[SExprPrimitive]*SExpr, 10)
+	if d.orderedMaps {
+		if omap == nil {
+			omap = NewOrderedMap(10)
+		}
+		e.omap = omap
+	} else {
+		if dict == nil {
+			dict = make(map[SExprPrimitive]*SExpr, 10)
+		}
+		e.dict = dict
+	}
 	for {
 		c, err = d.s.ReadByte()
 		if err != nil {
+			d.depth--
 			return
 		}
 		if c == ' ' {
@@ -95,26 +232,34 @@ func (d *Decoder) decodeMap(e *SExpr) (err error) {
 		}
 
 		if c == '}' {
+			d.depth--
 			return
 		}
 
 		err = d.s.UnreadByte()
 		if err != nil {
+			d.depth--
 			return
 		}
 
 		var key SExprPrimitive
-		var value *SExpr = &SExpr{}
+		var value *SExpr = d.alloc()
 		err = d.decodeMapEntry(&key, value)
 		if err != nil {
+			d.depth--
 			return
 		}
+		value = d.shared(value)
 
-		e.dict[key] = value
+		if e.omap != nil {
+			e.omap.Set(key, value)
+		} else {
+			e.dict[key] = value
+		}
 	}
 }
 
-func (d *Decoder) decodeMapEntry(key MutablePrimitive, value *SExpr) (err error) {
+func (d *Decoder) decodeMapEntry(key mutablePrimitive, value *SExpr) (err error) {
 	var c byte
 
 	c, err = d.s.ReadByte()
@@ -148,7 +293,7 @@ func (d *Decoder) decodeMapEntry(key MutablePrimitive, value *SExpr) (err error)
 	return
 }
 
-func (d *Decoder) decodeNode(p MutablePrimitive, e *SExpr) (err error) {
+func (d *Decoder) decodeNode(p mutablePrimitive, e *SExpr) (err error) {
 	var c byte
 	for {
 		c, err = d.s.ReadByte()
@@ -170,6 +315,11 @@ func (d *Decoder) decodeNode(p MutablePrimitive, e *SExpr) (err error) {
 					return
 				}
 
+				if d.isDeferred() {
+					err = d.decodeRaw(e)
+					return
+				}
+
 				err = d.decodeList(e)
 				return
 			}
@@ -183,6 +333,11 @@ func (d *Decoder) decodeNode(p MutablePrimitive, e *SExpr) (err error) {
 					return
 				}
 
+				if d.isDeferred() {
+					err = d.decodeRaw(e)
+					return
+				}
+
 				err = d.decodeMap(e)
 				return
 			}
@@ -190,72 +345,52 @@ func (d *Decoder) decodeNode(p MutablePrimitive, e *SExpr) (err error) {
 			return
 		}
 
-		if c == '#' {
-			err = d.decodeHexOctets(p)
-			return
-		}
-		if c == '"' {
-			err = d.decodeString(p)
-			return
-		}
-		if c == 'n' {
-			err = d.s.UnreadByte()
-			if err != nil {
-				return
-			}
-			for _, cc := range []byte("nil") {
-				c, err = d.s.ReadByte()
-				if err != nil {
-					return
-				}
-				if c != cc {
-					err = ErrUnexpectedCharacter
+		if c == '@' {
+			if e != nil {
+				if d.isDeferred() {
+					err = d.s.UnreadByte()
+					if err != nil {
+						return
+					}
+
+					err = d.decodeRaw(e)
 					return
 				}
-			}
 
-			err = nil
-			p.SetNil()
-			return
-		}
-		if c == 't' {
-			err = d.s.UnreadByte()
-			if err != nil {
+				err = d.decodeTagged(e)
 				return
 			}
-			for _, cc := range []byte("true") {
-				c, err = d.s.ReadByte()
-				if err != nil {
-					return
-				}
-				if c != cc {
-					err = ErrUnexpectedCharacter
-					return
-				}
-			}
-
-			err = nil
-			p.SetBool(true)
+			err = ErrNotPrimitive
 			return
 		}
-		if c == 'f' {
-			err = d.s.UnreadByte()
-			if err != nil {
-				return
-			}
-			for _, cc := range []byte("false") {
-				c, err = d.s.ReadByte()
-				if err != nil {
-					return
-				}
-				if c != cc {
-					err = ErrUnexpectedCharacter
-					return
+
+		if c == '&' || c == '*' {
+			if e != nil {
+				if c == '&' {
+					err = d.decodeLabel(e)
+				} else {
+					err = d.decodeReference()
 				}
+				return
 			}
+			err = ErrNotPrimitive
+			return
+		}
 
-			err = nil
-			p.SetBool(false)
+		if c == '#' {
+			err = d.decodeHexOctets(p, e)
+			return
+		}
+		if c == '"' {
+			err = d.decodeString(p)
+			return
+		}
+		if c == '%' {
+			err = d.decodeFloat(p)
+			return
+		}
+		if isSymbolStart(c) {
+			err = d.decodeIdentifier(p, c)
 			return
 		}
 
@@ -279,22 +414,178 @@ func (d *Decoder) decodeNode(p MutablePrimitive, e *SExpr) (err error) {
 	}
 }
 
-func (d *Decoder) decodeIntB16(e MutablePrimitive, negate bool) (err error) {
-	b := bytes.Buffer{}
-	b.Grow(17)
-	if negate {
-		b.WriteByte('-')
+// decodeTagged decodes a tag and its inner expression in parentheses after '@' has already been read.
+func (d *Decoder) decodeTagged(e *SExpr) (err error) {
+	var c byte
+	c, err = d.s.ReadByte()
+	if err != nil {
+		return
+	}
+	if !isSymbolStart(c) {
+		err = ErrUnexpectedCharacter
+		return
+	}
+
+	var tag SExprPrimitive
+	err = d.decodeIdentifier(&tag, c)
+	if err != nil {
+		return
+	}
+	if tag.kind != KindSymbol {
+		err = ErrInvalidTag
+		return
+	}
+
+	c, err = d.s.ReadByte()
+	if err != nil {
+		return
+	}
+	if c != '(' {
+		err = ErrUnexpectedCharacter
+		return
+	}
+
+	// an inner expression is required without leading whitespace:
+	c, err = d.s.ReadByte()
+	if err != nil {
+		return
+	}
+	if c == ')' || c == ' ' {
+		err = ErrUnexpectedCharacter
+		return
+	}
+	err = d.s.UnreadByte()
+	if err != nil {
+		return
+	}
+
+	inner := d.alloc()
+	err = d.decodeNode(inner, inner)
+	if err != nil {
+		return
+	}
+	inner = d.shared(inner)
+
+	c, err = d.s.ReadByte()
+	if err != nil {
+		return
+	}
+	if c != ')' {
+		err = ErrUnexpectedCharacter
+		return
+	}
+
+	err = setTagged(e, d.tags, tag.octets, inner)
+	return
+}
+
+// decodeLabel decodes a label number and '=' after '&' has already been read followed by the labeled expression.
+func (d *Decoder) decodeLabel(e *SExpr) (err error) {
+	var n uint64
+	n, err = d.decodeLabelNumber()
+	if err != nil {
+		return
+	}
+
+	var c byte
+	c, err = d.s.ReadByte()
+	if err != nil {
+		return
+	}
+	if c != '=' {
+		err = ErrUnexpectedCharacter
+		return
+	}
+
+	// the labeled expression must follow without whitespace:
+	c, err = d.s.ReadByte()
+	if err != nil {
+		return
+	}
+	if c == ' ' || c == ')' || c == '}' {
+		err = ErrUnexpectedCharacter
+		return
+	}
+	err = d.s.UnreadByte()
+	if err != nil {
+		return
+	}
+
+	d.labels, err = defineLabel(d.labels, n)
+	if err != nil {
+		return
+	}
+	i := len(d.labels) - 1
+
+	err = d.decodeNode(e, e)
+	if err != nil {
+		return
+	}
+
+	// a label may also be given to a reference:
+	if d.ref != nil {
+		e = d.ref
+	}
+	d.labels[i].e = e
+	return
+}
+
+// decodeReference decodes a label number after '*' has already been read and refers to its expression.
+func (d *Decoder) decodeReference() (err error) {
+	var n uint64
+	n, err = d.decodeLabelNumber()
+	if err != nil {
+		return
 	}
 
+	d.ref, err = resolveLabel(d.labels, n)
+	return
+}
+
+// decodeLabelNumber decodes the hex digits of a label number.
+func (d *Decoder) decodeLabelNumber() (v uint64, err error) {
+	n := 0
 	var c byte
 	for {
 		c, err = d.s.ReadByte()
 		if err != nil {
 			return
 		}
+		if !isHexDigit(c) {
+			break
+		}
 
-		// only allow hex digits:
-		if isHexDigit(c) {
+		x := hexDigitValue(c)
+		if v > (math.MaxUint64-x)>>4 {
+			err = strconv.ErrRange
+			return
+		}
+		v = v<<4 | x
+		n++
+	}
+
+	if n == 0 {
+		err = ErrUnexpectedCharacter
+		return
+	}
+
+	err = d.s.UnreadByte()
+	return
+}
+
+// decodeIdentifier decodes a keyword or symbol whose first character c has already been read.
+func (d *Decoder) decodeIdentifier(p mutablePrimitive, c byte) (err error) {
+	b := &d.buf
+	b.Reset()
+	b.WriteByte(c)
+
+	for {
+		c, err = d.s.ReadByte()
+		if err != nil {
+			return
+		}
+
+		if isSymbolChar(c) {
 			b.WriteByte(c)
 			continue
 		}
@@ -304,48 +595,133 @@ func (d *Decoder) decodeIntB16(e MutablePrimitive, negate bool) (err error) {
 			return
 		}
 
-		// signed:
-		var i64 int64
-		i64, err = strconv.ParseInt(b.String(), 16, 64)
-		e.SetInt64(i64)
+		setIdentifier(p, b.Bytes())
 		return
 	}
 }
 
-func isHexDigit(c byte) bool {
-	return ('0' <= c && c <= '9') || ('a' <= c && c <= 'f')
-}
+func (d *Decoder) decodeIntB16(e mutablePrimitive, negate bool) (err error) {
+	max := uint64(1<<63 - 1)
+	if negate {
+		max = 1 << 63
+	}
 
-func (d *Decoder) decodeHexOctets(e MutablePrimitive) (err error) {
-	// parse hex digits up to '$' as size:
-	sizeB := bytes.Buffer{}
+	var v uint64
+	n := 0
 	var c byte
 	for {
 		c, err = d.s.ReadByte()
 		if err != nil {
 			return
 		}
-		if c == '$' {
-			break
-		}
+
+		// only allow hex digits:
 		if isHexDigit(c) {
-			sizeB.WriteByte(c)
+			x := hexDigitValue(c)
+			if v > (max-x)>>4 {
+				err = strconv.ErrRange
+				return
+			}
+			v = v<<4 | x
+			n++
 			continue
 		}
 
+		err = d.s.UnreadByte()
+		if err != nil {
+			return
+		}
+
+		if n == 0 {
+			err = strconv.ErrSyntax
+			return
+		}
+
+		// signed:
+		if negate {
+			e.SetInt64(-int64(v))
+		} else {
+			e.SetInt64(int64(v))
+		}
+		return
+	}
+}
+
+// decodeFloat decodes exactly 16 hex digits of IEEE 754 bits following '%'.
+func (d *Decoder) decodeFloat(e mutablePrimitive) (err error) {
+	var v uint64
+	var c byte
+	for i := 0; i < 16; i++ {
+		c, err = d.s.ReadByte()
+		if err != nil {
+			return
+		}
+		if !isHexDigit(c) {
+			err = ErrUnexpectedCharacter
+			return
+		}
+		v = v<<4 | hexDigitValue(c)
+	}
+
+	// no more hex digits may follow:
+	c, err = d.s.ReadByte()
+	if err != nil {
+		return
+	}
+	if isHexDigit(c) {
 		err = ErrUnexpectedCharacter
 		return
 	}
+	err = d.s.UnreadByte()
+	if err != nil {
+		return
+	}
+
+	if !isCanonicalFloatBits(v) {
+		err = ErrNonCanonicalFloat
+		return
+	}
+
+	e.SetFloat64(math.Float64frombits(v))
+	return
+}
+
+func isHexDigit(c byte) bool {
+	return ('0' <= c && c <= '9') || ('a' <= c && c <= 'f')
+}
+
+// hexDigitValue returns the value of a hex digit already checked by isHexDigit.
+func hexDigitValue(c byte) uint64 {
+	if c <= '9' {
+		return uint64(c - '0')
+	}
+	return uint64(c - 'a' + 10)
+}
 
-	// parse the first hex digits as a size of octets to parse:
+// maxOctetsPrealloc limits how much buffer space is reserved based on an octets length alone.
+const maxOctetsPrealloc = 1 << 20
+
+func (d *Decoder) decodeHexOctets(p mutablePrimitive, e *SExpr) (err error) {
 	var size uint64
-	size, err = strconv.ParseUint(sizeB.String(), 16, 64)
+	size, err = d.decodeOctetsSize()
 	if err != nil {
 		return
 	}
 
-	// pre-allocate the buffer exactly sized:
-	data := bytes.NewBuffer(make([]byte, 0, size))
+	// map keys are never streamed:
+	if e != nil && d.octetsHandler != nil && size >= uint64(d.octetsMin) {
+		err = d.streamOctets(size)
+		return
+	}
+
+	// pre-allocate the buffer exactly sized, within reason until the data is actually read:
+	data := &d.buf
+	data.Reset()
+	if size <= maxOctetsPrealloc {
+		data.Grow(int(size))
+	} else {
+		data.Grow(maxOctetsPrealloc)
+	}
 
 	// parse hex digits as octets in pairs:
 	for i := uint64(0); i < size; i++ {
@@ -359,7 +735,40 @@ func (d *Decoder) decodeHexOctets(e MutablePrimitive) (err error) {
 		data.WriteByte(b)
 	}
 
-	e.SetOctets(data.String())
+	p.SetOctets(data.String())
+	return
+}
+
+// decodeOctetsSize parses hex digits up to '$' as the size of an octets atom.
+func (d *Decoder) decodeOctetsSize() (size uint64, err error) {
+	n := 0
+	var c byte
+	for {
+		c, err = d.s.ReadByte()
+		if err != nil {
+			return
+		}
+		if c == '$' {
+			break
+		}
+		if isHexDigit(c) {
+			x := hexDigitValue(c)
+			if size > (1<<63-1-x)>>4 {
+				err = strconv.ErrRange
+				return
+			}
+			size = size<<4 | x
+			n++
+			continue
+		}
+
+		err = ErrUnexpectedCharacter
+		return
+	}
+	if n == 0 {
+		err = strconv.ErrSyntax
+		return
+	}
 	return
 }
 
@@ -397,8 +806,9 @@ func (d *Decoder) readHexByte() (b byte, err error) {
 	return
 }
 
-func (d *Decoder) decodeString(e MutablePrimitive) (err error) {
-	b := bytes.Buffer{}
+func (d *Decoder) decodeString(e mutablePrimitive) (err error) {
+	b := &d.buf
+	b.Reset()
 
 	var c byte
 	for {
@@ -189,6 +189,46 @@ func TestDecoder_Decode(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		// symbols
+		{
+			name: "(read nil_ true-ish)",
+			fields: fields{
+				s: bytes.NewBuffer([]byte("(read nil_ true-ish)")),
+			},
+			wantE: &SExpr{
+				kind: KindList,
+				list: []*SExpr{
+					{
+						kind:   KindSymbol,
+						octets: "read",
+					},
+					{
+						kind:   KindSymbol,
+						octets: "nil_",
+					},
+					{
+						kind:   KindSymbol,
+						octets: "true-ish",
+					},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "({(mode $1)})",
+			fields: fields{
+				s: bytes.NewBuffer([]byte("({(mode $1)})")),
+			},
+			wantE: &SExpr{
+				kind: KindList,
+				list: []*SExpr{
+					MakeMap(map[SExprPrimitive]*SExpr{
+						PrimitiveSymbol("mode"): MakeInt64(1),
+					}),
+				},
+			},
+			wantErr: false,
+		},
 		// hex-octets
 		{
 			name: "(#1$00)",
@@ -299,3 +339,74 @@ func TestDecoder_Decode(t *testing.T) {
 		})
 	}
 }
+
+func TestDecoder_Release(t *testing.T) {
+	msgs := []string{
+		`("read" $7e0010 $10 ($1 $2) {("a" true)})`,
+		`("write" #2$0102 {("b" ($3))} "x")`,
+		`(($1 ($2 ($3))) nil)`,
+		`("read" $7e0010 $10 ($1 $2) {("a" true)})`,
+		// map entries without a value reuse released nodes as nil:
+		`({("a")} {("b")})`,
+	}
+
+	d := NewDecoder(nil)
+	for _, msg := range msgs {
+		d.Reset(bytes.NewReader([]byte(msg)))
+		e, err := d.Decode()
+		if err != nil {
+			t.Fatalf("Decode() error = %v", err)
+		}
+
+		want, err := NewDecoder(bytes.NewReader([]byte(msg))).Decode()
+		if err != nil {
+			t.Fatalf("Decode() error = %v", err)
+		}
+		if !reflect.DeepEqual(e.String(), want.String()) {
+			t.Fatalf("Decode() after Release() = %v, want %v", e, want)
+		}
+
+		d.Release(e)
+	}
+}
+
+func TestDecoder_ReleaseAllocs(t *testing.T) {
+	msg := []byte(`("read" $7e0010 -$10 ($1 $2) {("a" true)} #2$0102 nil)`)
+	r := bytes.NewReader(msg)
+	d := NewDecoder(r)
+
+	allocs := testing.AllocsPerRun(100, func() {
+		r.Reset(msg)
+		d.Reset(r)
+		e, err := d.Decode()
+		if err != nil {
+			t.Fatalf("Decode() error = %v", err)
+		}
+		d.Release(e)
+	})
+
+	// only the "read" and "a" strings and the octets data are expected to be allocated:
+	if allocs > 3 {
+		t.Errorf("Decode() allocs = %v, want <= 3", allocs)
+	}
+}
+
+func BenchmarkDecoder_Release(b *testing.B) {
+	for name, msg := range benchmarkMessages() {
+		b.Run(name, func(b *testing.B) {
+			r := bytes.NewReader(msg)
+			d := NewDecoder(r)
+			b.SetBytes(int64(len(msg)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				r.Reset(msg)
+				d.Reset(r)
+				e, err := d.Decode()
+				if err != nil {
+					b.Fatal(err)
+				}
+				d.Release(e)
+			}
+		})
+	}
+}
@@ -19,16 +19,23 @@ s-expression examples:
 	(#a$0102030405060708090a $3ff -$7f)
 	("abc\ndef\t\"123\"\x00\xff" () "12345")
 	{("a" 1) ("b" 2) ("c" 3) ("d" nil) ("e" false)}
+	(read $7e0010 {(mode fast)})
+	(%3ff8000000000000 %bff0000000000000 %7ff0000000000000)
+	(@time($63b9a0c0) @uuid(#10$00112233445566778899aabbccddeeff))
+	(&0=("tiles" #4$00010203) *0 *0)
 
 atom types:
 
 	nil
 	bool
 	integer
+	float
 	octets
 	string
+	symbol
 	list
 	map
+	tagged
 
 nil atom type:
 
@@ -52,6 +59,22 @@ integer atom type:
 		 `$3ff`  =   ( 1023)
 		`-$3ff`  =   (-1023)
 
+float atom type:
+
+	an IEEE 754 double-precision binary floating-point value
+	must start with '%'
+	followed by exactly 16 hex digits '0'..'9','a'..'f' encoding the 64 bits of the value, most significant first
+	cannot contain upper-case 'A'..'F'
+	all NaN values must be encoded as the single quiet NaN `%7ff8000000000000`
+	distinct encodings exist for +0 and -0, and for +Inf and -Inf
+
+	examples:
+		`%3ff8000000000000`  =  ( 1.5)
+		`%bff0000000000000`  =  (-1.0)
+		`%7ff0000000000000`  =  (+Inf)
+		`%fff0000000000000`  =  (-Inf)
+		`%7ff8000000000000`  =  (NaN)
+
 octets atom type:
 
 	leading '#' followed by <hex-digit>+ to specify the decoded data length
@@ -83,6 +106,20 @@ string atom type:
 	  "abc\ndef\t\"123\"\x00\xff"
 	  "12345"
 
+symbol atom type:
+
+	an unquoted identifier
+	must start with a letter 'a'..'z','A'..'Z' or '_'
+	followed by any number of letters, digits '0'..'9', '_', '-', '.' or '/'
+	cannot be one of the keywords "nil", "true" or "false"
+	symbols are distinct from strings with the same characters
+	intended for identifiers and enum-like values as opposed to user text
+
+	examples:
+	  `read`
+	  `write-mem`
+	  `snes/wram`
+
 list atom type:
 
 	list of s-expressions surrounded by '(' ')'
@@ -101,17 +138,53 @@ map atom type:
 	  {("a" $1) ("b" $2)}
 	  {(#1$0a $1) (#1$0b $2)}
 
+tagged atom type:
+
+	an inner s-expression annotated with a tag that names its application-defined type
+	leading '@' followed by the tag as a symbol
+	followed immediately by '(' then the inner s-expression then ')'
+	no white-space is allowed between '@', the tag, '(' and after the inner s-expression
+	cannot be used as a map key
+	decoders convert tagged values with registered tags to application types; unknown tags are preserved as is
+
+	examples:
+	  @time($63b9a0c0)
+	  @uuid(#10$00112233445566778899aabbccddeeff)
+	  @snes/addr(($7e $10))
+
+labels and references:
+
+	an s-expression may be labeled so that it can be referred back to later in the same top-level list
+	a label is a leading '&' followed by <hex-digit>+ as the label number followed by '=' then the s-expression
+	no white-space is allowed between '&', the label number, '=' and the s-expression
+	a reference is a leading '*' followed by <hex-digit>+ naming a label defined earlier
+	label numbers must be strictly increasing in order of definition; encoders number labels from 0
+	a reference may not appear within the s-expression of the label it refers to
+	neither labels nor references can be used as map keys
+	decoders resolve references to the same decoded s-expression rather than to copies of it
+	decoders limit the size of the decoded tree with all references expanded to guard against expansion bombs
+	a list or map deferred by a decoder as a raw s-expression may only refer to labels defined within itself
+
+	examples:
+	  (&0=("tiles" #4$00010203) *0 *0)
+	  {("a" &0=($1 $2)) ("b" *0)}
+
 BNF:
 
-	<sexpr>           :: <sexpr-primitive> | <sexpr-complex> ;
-	<sexpr-primitive> :: <nil> | <bool> | <integer> | <string> | <octets> ;
-	<sexpr-complex>   :: <list> | <map> ;
+	<sexpr>           :: <sexpr-primitive> | <sexpr-complex> | <label> | <reference> ;
+	<sexpr-primitive> :: <nil> | <bool> | <integer> | <float> | <string> | <octets> | <symbol> ;
+	<sexpr-complex>   :: <list> | <map> | <tagged> ;
 
 	<list>            :: '(' ( <sexpr> | <whitespace> )* ')' ;
 
 	<map>             :: '{' ( <map-entry> | <whitespace> )* '}' ;
 	<map-entry>       :: '(' <sexpr-primitive> <whitespace> <sexpr> ')' ;
 
+	<tagged>          :: '@' <symbol> '(' <sexpr> ')' ;
+
+	<label>           :: '&' <hex-digit>+ '=' <sexpr> ;
+	<reference>       :: '*' <hex-digit>+ ;
+
 	<nil>             :: 'n' 'i' 'l' ;
 
 	<bool>            :: <bool-true> | <bool-false> ;
@@ -123,6 +196,8 @@ BNF:
 	<hexadecimal>     :: '$' <hex-digit>+ ;
 	<hex-digit>       :: '0' | ... | '9' | 'a' | ... | 'f' ;
 
+	<float>           :: '%' <hex-digit>{16} ;
+
 	<octets>          :: '#' <hex-digit>+ '$' <hex-digit>* ;
 
 	<string>          :: '\"' ( <quoted-char> | <quoted-escape> )* '\"' ;
@@ -131,6 +206,10 @@ BNF:
 	<escape-single>   :: '\\' | '\"' | 'n' | 'r' | 't' ;
 	<escape-hex>      :: 'x' <hex-digit> <hex-digit> ;
 
+	<symbol>          :: <symbol-start> <symbol-char>* ; [except <nil> and <bool>]
+	<symbol-start>    :: 'a' | ... | 'z' | 'A' | ... | 'Z' | '_' ;
+	<symbol-char>     :: <symbol-start> | '0' | ... | '9' | '-' | '.' | '/' ;
+
 	<whitespace>      :: ' ' ;
 */
 package brass
@@ -0,0 +1,20 @@
+package brass
+
+import "math"
+
+// canonicalNaN is the only NaN bit pattern allowed in an encoded float atom.
+const canonicalNaN = 0x7ff8000000000000
+
+// floatBits returns the IEEE 754 bits of v with all NaN values canonicalized.
+func floatBits(v float64) int64 {
+	if v != v {
+		return canonicalNaN
+	}
+	return int64(math.Float64bits(v))
+}
+
+// isCanonicalFloatBits returns false for NaN bit patterns other than canonicalNaN.
+func isCanonicalFloatBits(b uint64) bool {
+	f := math.Float64frombits(b)
+	return f == f || b == canonicalNaN
+}
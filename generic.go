@@ -0,0 +1,79 @@
+package brass
+
+import "fmt"
+
+// ListOf makes a list by converting each element of v with f, e.g. ListOf(names, MakeString).
+func ListOf[T any](v []T, f func(T) *SExpr) *SExpr {
+	list := make([]*SExpr, len(v))
+	for i, x := range v {
+		list[i] = f(x)
+	}
+	return MakeList(list)
+}
+
+// DecodeList converts each element of a list with f, e.g. DecodeList(e, (*SExpr).Int64). Errors from f are
+// reported with the index of the element, and ErrWrongKind is returned if e is not a list.
+func DecodeList[T any](e *SExpr, f func(*SExpr) (T, error)) (v []T, err error) {
+	var list []*SExpr
+	list, err = e.List()
+	if err != nil {
+		return
+	}
+
+	v = make([]T, len(list))
+	for i, c := range list {
+		v[i], err = f(c)
+		if err != nil {
+			v = nil
+			err = atPath(Path{{Index: i}}, err)
+			return
+		}
+	}
+	return
+}
+
+// MapOf makes a map by converting each key of m with key and each value with value, e.g.
+// MapOf(counts, PrimitiveString, MakeInt64).
+func MapOf[K comparable, V any](m map[K]V, key func(K) SExprPrimitive, value func(V) *SExpr) *SExpr {
+	dict := make(map[SExprPrimitive]*SExpr, len(m))
+	for k, x := range m {
+		dict[key(k)] = value(x)
+	}
+	return MakeMap(dict)
+}
+
+// DecodeMap converts each key of a map with key and each value with value, e.g.
+// DecodeMap(e, (*SExprPrimitive).Str, (*SExpr).Int64). Entries are converted in the order they are walked so
+// that errors, which are reported with the key of the entry, are deterministic. ErrWrongKind is returned if e is
+// not a map.
+func DecodeMap[K comparable, V any](
+	e *SExpr,
+	key func(*SExprPrimitive) (K, error),
+	value func(*SExpr) (V, error),
+) (m map[K]V, err error) {
+	if err = checkKind(e.kind, KindMap); err != nil {
+		return
+	}
+
+	entries := mapEntries(e)
+	m = make(map[K]V, len(entries))
+	for i := range entries {
+		en := &entries[i]
+
+		var k K
+		k, err = key(&en.Key)
+		if err != nil {
+			m = nil
+			err = atPath(Path{{IsKey: true, Key: en.Key}}, fmt.Errorf("key: %w", err))
+			return
+		}
+
+		m[k], err = value(en.Value)
+		if err != nil {
+			m = nil
+			err = atPath(Path{{IsKey: true, Key: en.Key}}, err)
+			return
+		}
+	}
+	return
+}
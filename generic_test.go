@@ -0,0 +1,58 @@
+package brass
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestListOf(t *testing.T) {
+	e := ListOf([]string{"a", "b"}, MakeString)
+	if got, want := e.String(), `("a" "b")`; got != want {
+		t.Errorf("ListOf() = %s, want %s", got, want)
+	}
+
+	v, err := DecodeList(e, (*SExpr).Str)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a", "b"}; !reflect.DeepEqual(v, want) {
+		t.Errorf("DecodeList() = %v, want %v", v, want)
+	}
+
+	e, _, _ = DecodeBytes([]byte(`($1 $2 "3")`))
+	_, err = DecodeList(e, (*SExpr).Int64)
+	if !errors.Is(err, ErrWrongKind) || err.Error() != "[2]: wrong kind: expected KindInteger, got KindString" {
+		t.Errorf("DecodeList() error = %v", err)
+	}
+	if _, err = DecodeList(MakeNil(), (*SExpr).Int64); !errors.Is(err, ErrWrongKind) {
+		t.Errorf("DecodeList() error = %v, want %v", err, ErrWrongKind)
+	}
+}
+
+func TestMapOf(t *testing.T) {
+	m := map[string]int64{"a": 1, "b": 2}
+	e := MapOf(m, PrimitiveString, MakeInt64)
+
+	v, err := DecodeMap(e, (*SExprPrimitive).Str, (*SExpr).Int64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(v, m) {
+		t.Errorf("DecodeMap() = %v, want %v", v, m)
+	}
+
+	e, _, _ = DecodeBytes([]byte(`({("a" $1) ("b" nil) ($3 $3)})`))
+	e = e.AsList()[0]
+	_, err = DecodeMap(e, (*SExprPrimitive).Str, (*SExpr).Int64)
+	if !errors.Is(err, ErrWrongKind) || err.Error() != "[$3]: key: wrong kind: expected KindString, got KindInteger" {
+		t.Errorf("DecodeMap() error = %v", err)
+	}
+
+	e, _, _ = DecodeBytes([]byte(`({("a" $1) ("b" nil)})`))
+	e = e.AsList()[0]
+	_, err = DecodeMap(e, (*SExprPrimitive).Str, (*SExpr).Int64)
+	if !errors.Is(err, ErrWrongKind) || err.Error() != `["b"]: wrong kind: expected KindInteger, got KindNil` {
+		t.Errorf("DecodeMap() error = %v", err)
+	}
+}
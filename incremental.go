@@ -0,0 +1,183 @@
+package brass
+
+import "errors"
+
+var ErrIncomplete = errors.New("incomplete s-expression")
+var ErrFrameTooLarge = errors.New("s-expression exceeds maximum frame size")
+
+// DefaultMaxFrameSize is the default limit on the number of bytes in a single top-level list fed to an
+// IncrementalDecoder.
+const DefaultMaxFrameSize = 1 << 24
+
+// IncrementalDecoder decodes a stream of top-level lists from input that arrives in arbitrary chunks without
+// blocking. Input is supplied via Feed and complete expressions are retrieved via Next. Top-level lists may be
+// separated by any amount of ' ', '\r' and '\n' characters.
+//
+// Framing state is kept across calls so input is not rescanned while an expression is incomplete no matter how
+// it is split into chunks; each complete expression is then decoded from the buffer by DecodeBytes. When a
+// malformed expression is encountered its error is returned from Next and the remainder of its line is
+// discarded so that decoding can resume with the next line. Expressions larger than the maximum frame size are
+// discarded in the same way so that input without line breaks cannot grow the buffer without bound.
+type IncrementalDecoder struct {
+	buf []byte
+	// start is the offset in buf of the current expression:
+	start int
+	// scan is the offset in buf up to which input has been scanned:
+	scan int
+
+	// depth is the number of unterminated lists and maps:
+	depth int
+	// inString is set while scanning a string atom:
+	inString bool
+	// escaped is set after a '\' in a string atom:
+	escaped bool
+	// discarding is set while skipping to the end of a malformed line:
+	discarding bool
+	// maxFrameSize limits the size of an expression; 0 selects DefaultMaxFrameSize:
+	maxFrameSize int
+}
+
+func NewIncrementalDecoder() *IncrementalDecoder {
+	return &IncrementalDecoder{}
+}
+
+// SetMaxFrameSize sets the limit on the number of bytes in a single top-level list. Next fails with
+// ErrFrameTooLarge when the limit is exceeded and discards the rest of the line. A limit of 0 selects
+// DefaultMaxFrameSize.
+func (d *IncrementalDecoder) SetMaxFrameSize(n int) {
+	d.maxFrameSize = n
+}
+
+// Feed appends a chunk of input. The chunk is copied so the caller may reuse p after Feed returns.
+func (d *IncrementalDecoder) Feed(p []byte) {
+	if d.start > 0 {
+		// discard consumed input:
+		n := copy(d.buf, d.buf[d.start:])
+		d.buf = d.buf[:n]
+		d.scan -= d.start
+		d.start = 0
+	}
+
+	d.buf = append(d.buf, p...)
+}
+
+// Buffered returns the number of bytes fed but not yet consumed by a complete expression.
+func (d *IncrementalDecoder) Buffered() int {
+	return len(d.buf) - d.start
+}
+
+// Next returns the next complete expression. If there is not enough input to complete the next expression then
+// ErrIncomplete is returned and Next should be called again after more input is fed. Any other error pertains
+// only to the expression it was found in; Next may be called again to continue decoding.
+func (d *IncrementalDecoder) Next() (e *SExpr, err error) {
+	max := d.maxFrameSize
+	if max <= 0 {
+		max = DefaultMaxFrameSize
+	}
+
+	for ; d.scan < len(d.buf); d.scan++ {
+		c := d.buf[d.scan]
+
+		if d.discarding {
+			// discarded input is not retained:
+			d.start = d.scan + 1
+			if c == '\n' {
+				d.discarding = false
+			}
+			continue
+		}
+
+		if d.scan-d.start >= max {
+			d.fail()
+			err = ErrFrameTooLarge
+			return
+		}
+
+		if c == '\r' || c == '\n' {
+			if d.depth == 0 {
+				d.start = d.scan + 1
+				continue
+			}
+
+			// newlines must not appear within an expression:
+			d.fail()
+			err = ErrUnexpectedCharacter
+			return
+		}
+
+		if d.inString {
+			if d.escaped {
+				d.escaped = false
+			} else if c == '\\' {
+				d.escaped = true
+			} else if c == '"' {
+				d.inString = false
+			}
+			continue
+		}
+
+		if d.depth == 0 {
+			if c == ' ' {
+				d.start = d.scan + 1
+				continue
+			}
+			if c != '(' {
+				d.fail()
+				err = ErrUnexpectedCharacter
+				return
+			}
+		}
+
+		switch c {
+		case '"':
+			d.inString = true
+		case '(', '{':
+			d.depth++
+		case ')', '}':
+			d.depth--
+			if d.depth == 0 {
+				d.scan++
+				e, err = d.decodeFrame(d.buf[d.start:d.scan])
+				d.start = d.scan
+				if err != nil {
+					// discard the rest of the line as for other malformed expressions:
+					d.discarding = true
+				}
+				return
+			}
+		}
+	}
+
+	err = ErrIncomplete
+	return
+}
+
+// fail resets the scanner state and discards input up to the next newline.
+func (d *IncrementalDecoder) fail() {
+	d.depth = 0
+	d.inString = false
+	d.escaped = false
+	d.discarding = true
+	d.scan++
+	d.start = d.scan
+	if d.scan > 0 && d.buf[d.scan-1] == '\n' {
+		// the offending character was the newline itself:
+		d.discarding = false
+	}
+}
+
+func (d *IncrementalDecoder) decodeFrame(frame []byte) (e *SExpr, err error) {
+	var n int
+	e, n, err = DecodeBytes(frame)
+	if err != nil {
+		e = nil
+		return
+	}
+	if n != len(frame) {
+		e = nil
+		err = ErrUnexpectedCharacter
+		return
+	}
+
+	return
+}
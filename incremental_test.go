@@ -0,0 +1,156 @@
+package brass
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestIncrementalDecoder_Next(t *testing.T) {
+	tests := []struct {
+		name      string
+		input     string
+		chunkSize int
+		want      []string
+		wantErrs  int
+	}{
+		{
+			name:      "whole",
+			input:     "($1 \"a\")\n(() {(\"k\" nil)})\n",
+			chunkSize: 1 << 10,
+			want:      []string{`($1 "a")`, `(() {("k" nil)})`},
+		},
+		{
+			name:      "bytewise",
+			input:     "($1 \"a)\\\"(\")\r\n (#3$616263 (true))(false)",
+			chunkSize: 1,
+			want:      []string{`($1 "a)\"(")`, `(#3$616263 (true))`, `(false)`},
+		},
+		{
+			name:      "chunked",
+			input:     "(\"abcdefghijklmnopqrstuvwxyz\" $123456789)\n($2)\n",
+			chunkSize: 7,
+			want:      []string{`("abcdefghijklmnopqrstuvwxyz" $123456789)`, `($2)`},
+		},
+		{
+			name:      "resync after garbage",
+			input:     "garbage ($1)\n($2)\n",
+			chunkSize: 3,
+			want:      []string{`($2)`},
+			wantErrs:  1,
+		},
+		{
+			name:      "resync after newline in list",
+			input:     "($1 \n($2)\n",
+			chunkSize: 2,
+			want:      []string{`($2)`},
+			wantErrs:  1,
+		},
+		{
+			name:      "invalid frame",
+			input:     "($1 $x)\n($3)",
+			chunkSize: 4,
+			want:      []string{`($3)`},
+			wantErrs:  1,
+		},
+		{
+			name:      "invalid frame discards line",
+			input:     "($1 $x) ($2)\n($3)",
+			chunkSize: 5,
+			want:      []string{`($3)`},
+			wantErrs:  1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewIncrementalDecoder()
+
+			var got []string
+			errs := 0
+			input := []byte(tt.input)
+			for len(input) > 0 {
+				n := tt.chunkSize
+				if n > len(input) {
+					n = len(input)
+				}
+				d.Feed(input[:n])
+				input = input[n:]
+
+				for {
+					e, err := d.Next()
+					if errors.Is(err, ErrIncomplete) {
+						break
+					}
+					if err != nil {
+						errs++
+						continue
+					}
+					got = append(got, e.String())
+				}
+			}
+
+			if errs != tt.wantErrs {
+				t.Errorf("Next() errors = %v, want %v", errs, tt.wantErrs)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("Next() got %q, want %q", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("Next() got %q, want %q", got[i], tt.want[i])
+				}
+			}
+			if d.Buffered() != 0 {
+				t.Errorf("Buffered() = %v, want 0", d.Buffered())
+			}
+		})
+	}
+}
+
+func TestIncrementalDecoder_SetMaxFrameSize(t *testing.T) {
+	d := NewIncrementalDecoder()
+	d.SetMaxFrameSize(16)
+
+	d.Feed([]byte("($1 $2)\n("))
+	e, err := d.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := e.String(); got != "($1 $2)" {
+		t.Errorf("Next() = %q, want %q", got, "($1 $2)")
+	}
+
+	// a frame which never ends must not be buffered without bound:
+	tooLarge := 0
+	for i := 0; i < 100; i++ {
+		d.Feed([]byte("$123 "))
+		for {
+			_, err = d.Next()
+			if errors.Is(err, ErrIncomplete) {
+				break
+			}
+			if !errors.Is(err, ErrFrameTooLarge) {
+				t.Fatalf("Next() error = %v, want %v", err, ErrFrameTooLarge)
+			}
+			tooLarge++
+		}
+		if d.Buffered() > 16 {
+			t.Fatalf("Buffered() = %v, want at most 16", d.Buffered())
+		}
+	}
+	if tooLarge != 1 {
+		t.Errorf("Next() returned %v errors, want 1", tooLarge)
+	}
+
+	// decoding resumes with the next line:
+	d.Feed([]byte("$4)\n($3)\n"))
+	e, err = d.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := e.String(); got != "($3)" {
+		t.Errorf("Next() = %q, want %q", got, "($3)")
+	}
+	if d.Buffered() != 1 {
+		t.Errorf("Buffered() = %v, want 1", d.Buffered())
+	}
+}
@@ -70,3 +70,30 @@ function fromhex_e(s, len)
     end
     return table.concat(l), me, nil
 end
+
+function fromhex_f(s, len)
+    local me = 1
+    -- build string of bytes in chunks since unpack is limited by the C stack size:
+    local l = {}
+    local c = {}
+    for i = 0,len-1,4096 do
+        local n = math.min(4096, len-i)
+        for j = 1,n do
+            c[j] = tonumber(s:sub(me,me+1), 16)
+            me = me + 2
+        end
+        l[#l+1] = string.char(unpack(c, 1, n))
+    end
+    return table.concat(l), me, nil
+end
+
+local hexpairs = {}
+for i = 0,255 do
+    hexpairs[string.format('%02x', i)] = string.char(i)
+end
+
+function fromhex_g(s, len)
+    local me = 1
+    -- replace each pair of hex digits via a lookup table:
+    return (s:sub(me, me+len*2-1):gsub('..', hexpairs)), me+len*2, nil
+end
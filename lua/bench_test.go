@@ -47,7 +47,7 @@ func BenchmarkFromHex(b *testing.B) {
 		}
 	}
 
-	for _, fname := range []string{"fromhex_a1", "fromhex_a2", "fromhex_b", "fromhex_c", "fromhex_d", "fromhex_e"} {
+	for _, fname := range []string{"fromhex_a1", "fromhex_a2", "fromhex_b", "fromhex_c", "fromhex_d", "fromhex_e", "fromhex_f", "fromhex_g"} {
 		b.Run(fname, benchFunc(l.GetGlobal(fname)))
 	}
 }
@@ -1,6 +1,23 @@
--- Brass: a custom s-expression encoder and decoder library for Lua 5.1
+-- Brass: a custom s-expression encoder and decoder library for Lua 5.1 and later
 -- Version 20230128
 --
+-- Integers are handled according to the version of Lua:
+--
+--   Lua 5.1 and 5.2 have only double numbers. integer atoms must be within the 52-bit range of the encoding and
+--   fail to decode with a 'value out of range' error otherwise. the Go decoder accepts integers up to 64 bits and
+--   fails with the same error, strconv.ErrRange, only beyond them; SExpr.Validate checks the 52-bit range in Go.
+--   integral numbers within that range are encoded as integer atoms, integral numbers beyond it fail to encode
+--   with an 'integer exceeds 52 bits' error and all others are encoded as float atoms; use brass.float to encode
+--   an integral number as a float.
+--
+--   Lua 5.3 and later have a native 64-bit integer subtype, detected via math.type. integer atoms decode to
+--   integers and fail with a 'value out of range' error beyond the 64-bit range as in Go. numbers are encoded
+--   according to their subtype: integers as integer atoms and floats as float atoms even when they are integral.
+--   integers beyond the 52-bit range fail to encode with an 'integer exceeds 52 bits' error since other
+--   implementations may not accept them, unless the wide_integers option of brass.encode is given.
+--   the tests run under gopher-lua which only has double numbers, so the integer subtype is simulated there and
+--   integers which are not exactly representable by doubles, such as those near math.maxinteger, are untested.
+--
 -- Copyright jsd1982 2023
 --
 -- MIT License
@@ -25,69 +42,337 @@ if table.unpack ~= nil then
     unpack = table.unpack
 end
 
+-- Lua 5.3 and later have native 64-bit integers:
+local native_integers = math.type ~= nil
+brass.native_integers = native_integers
+
+-- the largest magnitude of integers allowed by the encoding without native integers, within which all doubles
+-- are exact:
+local max_integer = 2^52 - 1
+
 local decode_list
+local decode_map
+
+-- the metatable of maps. maps are marked by it rather than a __brass_kind field since that is also a valid key;
+-- reading __brass_kind still gives 'map' unless the map has an entry with that key:
+local map_mt = { __index = { __brass_kind = 'map' } }
+
+-- returns the kind of a table, e.g. 'list' or 'map':
+local function kind_of(e)
+    if getmetatable(e) == map_mt then
+        return 'map'
+    end
+    return e.__brass_kind
+end
+
+-- returns the table t, or a new table if t is nil, marked as a map so that all of its entries are encoded as map
+-- entries, including one keyed by '__brass_kind':
+function brass.map(t)
+    return setmetatable(t or {}, map_mt)
+end
+
+-- the nil atom used as a map key since Lua tables cannot have nil keys:
+brass.null = { __brass_kind = 'nil' }
+
+-- interned symbols and octets used as map keys so that equal keys are the same table:
+local symbol_keys = setmetatable({}, { __mode = 'v' })
+local octets_keys = setmetatable({}, { __mode = 'v' })
+
+-- returns the symbol map key for name:
+function brass.symbol_key(name)
+    local k = symbol_keys[name]
+    if k == nil then
+        k = { __brass_kind = 'symbol', name = name }
+        symbol_keys[name] = k
+    end
+    return k
+end
+
+-- returns the octets map key for the bytes of the binary string s:
+function brass.octets_key(s)
+    local k = octets_keys[s]
+    if k == nil then
+        k = brass.octets(s)
+        octets_keys[s] = k
+    end
+    return k
+end
+
+-- wraps a binary string so that it is encoded as an octets atom rather than a string; octets are decoded as such
+-- wrappers unless the 'table' octets option is given:
+function brass.octets(data)
+    return { __brass_kind = 'octets', data = data }
+end
+
+-- the number of octets converted at a time since unpack and string.byte are limited by the C stack size:
+local octets_chunk = 4096
+
+-- converts len pairs of hex digits in h to a binary string. this is fromhex_f in bench.lua; BenchmarkFromHex
+-- decoding 32 KiB under gopher-lua measured 23 ms per call against 29 ms for the next fastest, fromhex_d, and
+-- 1.4 s for a gsub lookup table, fromhex_g:
+local function fromhex(h, len)
+    local l = {}
+    local c = {}
+    local me = 1
+    for i = 0,len-1,octets_chunk do
+        local n = math.min(octets_chunk, len-i)
+        for j = 1,n do
+            c[j] = tonumber(h:sub(me,me+1), 16)
+            me = me + 2
+        end
+        l[#l+1] = string.char(unpack(c, 1, n))
+    end
+    return table.concat(l)
+end
+
+-- converts a binary string to pairs of hex digits:
+local function tohex(data)
+    local l = {}
+    for i = 1,#data,octets_chunk do
+        local j = math.min(i+octets_chunk-1, #data)
+        l[#l+1] = string.format(string.rep('%02x', j-i+1), data:byte(i, j))
+    end
+    return table.concat(l)
+end
+
+-- returns the binary string of octets in either the wrapped or the table form:
+local function octets_data(e)
+    if e.data ~= nil then
+        return e.data
+    end
+    local l = {}
+    for i = 1,#e,octets_chunk do
+        l[#l+1] = string.char(unpack(e, i, math.min(i+octets_chunk-1, #e)))
+    end
+    return table.concat(l)
+end
+
+-- some Lua implementations define math.huge as the largest finite number:
+local inf = 1/0
+
+-- converts 16 hex digits of IEEE 754 double bits to a number:
+local function float_from_hex(h)
+    local hi = tonumber(h:sub(1,8), 16)
+    local lo = tonumber(h:sub(9,16), 16)
+
+    local sign = 1
+    if hi >= 0x80000000 then
+        sign = -1
+        hi = hi - 0x80000000
+    end
+
+    local exp = math.floor(hi / 0x100000)
+    local mant = (hi % 0x100000) * 0x100000000 + lo
+    if exp == 0x7ff then
+        if mant == 0 then
+            return sign * inf
+        end
+        return 0/0
+    elseif exp == 0 then
+        -- subnormal:
+        return sign * mant * 2^-1074
+    end
+    return sign * (mant + 2^52) * 2^(exp - 1075)
+end
+
+-- converts a number to 16 hex digits of IEEE 754 double bits:
+local function float_to_hex(x)
+    if x ~= x then
+        -- canonical NaN:
+        return '7ff8000000000000'
+    end
+
+    local sign = 0
+    if x < 0 or (x == 0 and 1/x < 0) then
+        sign = 0x80000000
+        x = -x
+    end
+
+    local exp, mant
+    if x == inf then
+        exp, mant = 0x7ff, 0
+    elseif x == 0 then
+        exp, mant = 0, 0
+    else
+        local e = math.floor(math.log(x) / math.log(2))
+        -- correct for rounding errors in log:
+        if 2^e > x then
+            e = e - 1
+        elseif 2^(e+1) <= x then
+            e = e + 1
+        end
+
+        if e < -1022 then
+            -- subnormal:
+            exp, mant = 0, x * 2^1022 * 2^52
+        else
+            exp, mant = e + 1023, (x / 2^e - 1) * 2^52
+        end
+    end
+
+    local hi = sign + exp * 0x100000 + math.floor(mant / 0x100000000)
+    local lo = mant % 0x100000000
+    return string.format('%08x%08x', hi, lo)
+end
+
+-- error objects returned by the decoder. kind is the category of the error which matches the message of the
+-- corresponding Go error, e.g. 'unexpected character' for brass.ErrUnexpectedCharacter, and err describes it in
+-- detail. pos is the position in the input where the error was found, expected describes what was expected
+-- there, if anything, excerpt is the input surrounding pos and path is the nesting path of the expression
+-- containing the error formatted as by brass.Path in Go, e.g. [1]["a"].
+local decode_error = {}
+decode_error.__index = decode_error
+
+function decode_error:__tostring()
+    local t = { self.kind, ': ', self.err, ' at position ', tostring(self.pos) }
+    if self.expected ~= nil then
+        t[#t+1] = ', expected ' .. self.expected
+    end
+    if self.path ~= '' then
+        t[#t+1] = ' in ' .. self.path
+    end
+    t[#t+1] = ' near ' .. string.format('%q', self.excerpt)
+    return table.concat(t)
+end
+
+-- the error kinds:
+local unexpected_character = 'unexpected character'
+local unexpected_eof = 'unexpected EOF'
+local not_primitive = 'unexpected primitive type'
+local non_canonical_float = 'non-canonical NaN float encoding'
+local out_of_range = 'value out of range'
+local unrepresentable = 'value cannot be represented'
 
-local function decode_atom(s, ms)
+-- returns nil, pos and an error object for an error found at pos in s:
+local function fail(s, pos, kind, err, expected)
+    return nil, pos, setmetatable({
+        kind = kind,
+        err = err,
+        pos = pos,
+        expected = expected,
+        excerpt = s:sub(math.max(1, pos-16), pos+16),
+        path = '',
+    }, decode_error)
+end
+
+-- fails at the end of input or else on an unexpected character:
+local function fail_at(s, pos, err, expected)
+    if pos > #s then
+        return fail(s, pos, unexpected_eof, err, expected)
+    end
+    return fail(s, pos, unexpected_character, err, expected)
+end
+
+local function decode_atom(s, ms, opts)
     local m, me
 
+    if ms > #s then
+        return fail(s, ms, unexpected_eof, 'unexpected end of input', 'an expression')
+    end
+
     -- check if start of list:
     m = s:match('^()[%(]', ms)
     if m ~= nil then
-        return decode_list(s, m)
+        return decode_list(s, m, opts)
     end
 
-    -- check for nil:
-    me = s:match('^nil()', ms)
-    if me ~= nil then
-        return { __brass_kind = 'nil' }, me, nil
+    -- check if start of map:
+    m = s:match('^(){', ms)
+    if m ~= nil then
+        return decode_map(s, m, opts)
     end
 
-    -- check for true:
-    me = s:match('^true()', ms)
+    -- check for keyword or symbol:
+    me = s:match('^[A-Za-z_][A-Za-z0-9_%-%./]*()', ms)
     if me ~= nil then
-        return true, me, nil
+        local v = s:sub(ms, me-1)
+        if v == 'nil' then
+            return { __brass_kind = 'nil' }, me, nil
+        elseif v == 'true' then
+            return true, me, nil
+        elseif v == 'false' then
+            return false, me, nil
+        end
+        return { __brass_kind = 'symbol', name = v }, me, nil
     end
 
-    -- check for false:
-    me = s:match('^false()', ms)
+    -- check for hexadecimal integer:
+    me = s:match('^[%-]?%$[0-9a-f]+()', ms)
     if me ~= nil then
-        return false, me, nil
+        local negative = s:sub(ms, ms) == '-'
+        local digits = s:sub(negative and ms+2 or ms+1, me-1):match('^0*(.-)$')
+        if native_integers then
+            -- tonumber wraps around beyond 64 bits:
+            local x = tonumber(digits ~= '' and digits or '0', 16)
+            if #digits > 16 or (x < 0 and not (negative and x == math.mininteger)) then
+                return fail(s, ms, out_of_range, 'integer exceeds 64 bits', 'a 64-bit integer')
+            end
+            if negative then
+                x = -x
+            end
+            return x, me, nil
+        end
+
+        local x = tonumber(digits ~= '' and digits or '0', 16)
+        if #digits > 13 or x > max_integer then
+            return fail(s, ms, out_of_range, 'integer exceeds 52 bits', 'a 52-bit integer')
+        end
+        if negative then
+            x = -x
+        end
+        return x, me, nil
     end
 
-    -- check for hexadecimal integer:
-    me = s:match('^[%-]?%$[0-9a-f]+()', ms)
+    -- check for float:
+    me = s:match('^%%[0-9a-f]*()', ms)
     if me ~= nil then
-        local v = s:sub(ms, me-1)
-        local g = v:sub(1,1)
-        if g == '-' then
-            return -tonumber(v:sub(3), 16), me, nil
-        else
-            return tonumber(v:sub(2), 16), me, nil
+        if me - ms ~= 17 then
+            return fail_at(s, me, 'float must have exactly 16 hex digits', '16 hex digits')
+        end
+        local h = s:sub(ms+1, me-1)
+        if h:match('^[7f]ff') ~= nil and h:sub(4) ~= '0000000000000' and h ~= '7ff8000000000000' then
+            return fail(s, ms, non_canonical_float, 'non-canonical NaN float encoding', '%7ff8000000000000')
         end
+        return float_from_hex(h), me, nil
     end
 
     -- check for hex-octets:
-    me = s:match('^#[0-9a-f]+%$()', ms)
-    if me ~= nil then
+    if s:sub(ms, ms) == '#' then
+        me = s:match('^#[0-9a-f]+()', ms)
+        if me == nil then
+            return fail_at(s, ms+1, 'invalid hex-octet length', 'hex digit')
+        end
+        if s:sub(me, me) ~= '$' then
+            return fail_at(s, me, 'invalid hex-octet length', "'$'")
+        end
+
         -- parse length in hex:
-        local len = tonumber(s:sub(ms+1, me-2), 16)
+        local len = tonumber(s:sub(ms+1, me-1), 16)
+        me = me + 1
 
         -- extract hex digits:
         local he = me-1+len*2
+        local h = s:sub(me, he)
+        local bad = h:find('[^0-9a-f]')
+        if bad ~= nil then
+            return fail(s, me+bad-1, unexpected_character, 'invalid hex-octet digit', 'hex digit')
+        end
         if he > #s then
-            return nil, ms, { err = 'hex-octet sequence length incorrect' }
+            return fail(s, #s+1, unexpected_eof, 'hex-octet sequence length incorrect', 'hex digit')
         end
+        if opts ~= nil and opts.octets == 'table' then
+            -- build list of octet values:
+            local l = {}
+            l.__brass_kind = 'octets'
 
-        -- build list of octet values:
-        local l = {}
-        l.__brass_kind = 'octets'
+            for i = 0,len-1 do
+                l[#l+1] = tonumber(h:sub(i*2+1,i*2+2), 16)
+            end
 
-        for i = 0,len-1 do
-            l[#l+1] = tonumber(s:sub(me,me+1), 16)
-            me = me + 2
+            return l, he+1, nil
         end
 
-        return l, me, nil
+        return brass.octets(fromhex(h, len)), he+1, nil
     end
 
     -- check for string:
@@ -99,7 +384,7 @@ local function decode_atom(s, ms)
         -- more complex string with escaped chars:
         ms = ms + 1
         local l = {}
-        while ms <= #s do
+        while true do
             me = s:match('^[^"\\\r\n]+()', ms)
             if me ~= nil then
                 l[#l+1] = s:sub(ms,me-1)
@@ -113,7 +398,7 @@ local function decode_atom(s, ms)
             elseif ec == '\\' then
                 -- handle escapes:
                 ms = me + 1
-                local hx = s:match('x([0-9a-f][0-9a-f])', ms)
+                local hx = s:match('^x([0-9a-f][0-9a-f])', ms)
                 if hx ~= nil then
                     ms = ms + 3
                     l[#l+1] = string.char(tonumber(hx,16))
@@ -130,121 +415,448 @@ local function decode_atom(s, ms)
                     elseif ec == '"' then
                         l[#l+1] = '"'
                     else
-                        return nil, ms, { err = 'invalid escape sequence' }
+                        return fail_at(s, ms, 'invalid escape sequence', "one of t r n \\ \" or x and 2 hex digits")
                     end
                     ms = ms + 1
                 end
+            elseif me > #s then
+                return fail(s, me, unexpected_eof, 'unterminated string literal', "'\"'")
             else
-                return nil, me, { err = 'invalid string literal' }
+                return fail(s, me, unexpected_character, 'invalid string literal', "'\"'")
             end
         end
     end
 
-    return nil, ms, { err = 'unrecognized brass s-expression' }
+    return fail(s, ms, unexpected_character, 'unrecognized brass s-expression', 'an expression')
+end
+
+-- prepends a path element to the path of an error found within a list or map:
+local function at_path(err, elem)
+    err.path = '[' .. elem .. ']' .. err.path
+    return err
 end
 
-decode_list = function (s, ms)
+decode_list = function (s, ms, opts)
     local l = {}
     l.__brass_kind = 'list'
 
     ms = ms + 1
-    while ms <= #s do
+    while true do
         -- skip whitespace
-        local we = s:match('^[% ]*()', ms)
-        if we ~= nil then
-            ms = we
-        end
+        ms = s:match('^[% ]*()', ms)
 
         -- end of list?
         if s:sub(ms, ms) == ')' then
             return l, ms+1, nil
         end
+        if ms > #s then
+            return l, select(2, fail(s, ms, unexpected_eof, 'unexpected end of list', "')'"))
+        end
 
         -- decode list item:
-        local child, me, err = decode_atom(s, ms)
+        local child, me, err = decode_atom(s, ms, opts)
         if err ~= nil then
-            return l, me, err
+            return l, me, at_path(err, #l)
         end
         ms = me
 
         l[#l+1] = child
     end
+end
+
+-- converts a decoded primitive to its map key:
+local function map_key(k)
+    if type(k) ~= 'table' then
+        return k
+    elseif k.__brass_kind == 'nil' then
+        return brass.null
+    elseif k.__brass_kind == 'symbol' then
+        return brass.symbol_key(k.name)
+    elseif k.__brass_kind == 'octets' then
+        return brass.octets_key(octets_data(k))
+    end
+    return k
+end
+
+-- decodes a map into a table keyed by the map keys. strings, numbers and booleans are used as keys as is, nil is
+-- brass.null, and symbols and octets are the tables returned by brass.symbol_key and brass.octets_key. later
+-- entries replace earlier entries with the same key. maps are marked as by brass.map.
+decode_map = function (s, ms, opts)
+    local l = brass.map()
+
+    ms = ms + 1
+    while true do
+        -- skip whitespace
+        ms = s:match('^[% ]*()', ms)
+
+        -- end of map?
+        local c = s:sub(ms, ms)
+        if c == '}' then
+            return l, ms+1, nil
+        elseif ms > #s then
+            return l, select(2, fail(s, ms, unexpected_eof, 'unexpected end of map', "'}'"))
+        elseif c ~= '(' then
+            return l, select(2, fail(s, ms, unexpected_character, 'expected map entry', "'(' or '}'"))
+        end
+
+        -- decode key:
+        ms = s:match('^[% ]*()', ms+1)
+        c = s:sub(ms, ms)
+        if c == '(' or c == '{' then
+            return l, select(2, fail(s, ms, not_primitive, 'map key must be a primitive', 'a primitive'))
+        end
+        local k, me, err = decode_atom(s, ms, opts)
+        if err ~= nil then
+            return l, me, err
+        end
+        if k ~= k then
+            return l, select(2, fail(s, ms, unrepresentable, 'NaN map keys are not supported'))
+        end
+
+        -- decode value:
+        ms = s:match('^[% ]*()', me)
+        local v
+        v, me, err = decode_atom(s, ms, opts)
+        if err ~= nil then
+            return l, me, at_path(err, brass.encode(k))
+        end
+
+        if s:sub(me, me) ~= ')' then
+            me, err = select(2, fail_at(s, me, 'expected end of map entry', "')'"))
+            return l, me, at_path(err, brass.encode(k))
+        end
+        ms = me + 1
+
+        l[map_key(k)] = v
+    end
+end
 
-    return l, me, { err = 'unexpected end of list' }
+-- decodes the top-level list at the start of s. opts is an optional table of options:
+--   octets = 'table' decodes octets as the old form of a table of byte values rather than brass.octets wrappers
+-- returns the list and the position following it, or a partial list, the position of the error and an error
+-- object which is also converted to a descriptive message by tostring.
+function brass.decode(s, opts)
+    if s:sub(1, 1) ~= '(' then
+        return { __brass_kind = 'list' }, select(2, fail_at(s, 1, 'expected list', "'('"))
+    end
+    return decode_list(s, 1, opts)
 end
 
-function brass.decode(s)
-    local expr, me, err = decode_list(s, 1)
-    return expr, me, err
+-- stream decodes line-framed expressions from input that arrives in arbitrary chunks, e.g. from a socket's
+-- receive. each line holds one top-level list; empty lines and trailing ' ' and '\r' characters are ignored.
+local stream = {}
+stream.__index = stream
+
+-- creates a stream decoder which decodes with the options of brass.decode:
+function brass.stream(opts)
+    return setmetatable({ buf = '', pos = 1, scan = 1, pending = {}, pending_len = 0, opts = opts }, stream)
 end
 
-function brass.encode(e)
+-- appends a chunk of input. chunks without a newline are collected in pending and only joined to buf once a
+-- newline arrives so that a long line fed in many chunks is not copied for each chunk:
+function stream:feed(chunk)
+    if chunk:find('\n', 1, true) == nil then
+        self.pending[#self.pending+1] = chunk
+        self.pending_len = self.pending_len + #chunk
+        return
+    end
+
+    -- discard consumed input:
+    local rest = self.buf:sub(self.pos)
+    self.scan = self.scan - self.pos + 1
+    self.pos = 1
+
+    self.pending[#self.pending+1] = chunk
+    self.buf = rest .. table.concat(self.pending)
+    self.pending = {}
+    self.pending_len = 0
+end
+
+-- returns the number of bytes fed but not yet consumed by a complete line:
+function stream:buffered()
+    return #self.buf - self.pos + 1 + self.pending_len
+end
+
+-- returns the next complete expression. returns nil, err if the next line is malformed; the line is consumed so
+-- next may be called again to continue with the following line. returns nil, nil if no complete line is buffered.
+function stream:next()
+    while true do
+        local nl = self.buf:find('\n', self.scan, true)
+        if nl == nil then
+            -- remember where to resume searching for the end of the line:
+            self.scan = #self.buf + 1
+            return nil, nil
+        end
+
+        local line = self.buf:sub(self.pos, nl - 1):match('^ *(.-)[ \r]*$')
+        self.pos = nl + 1
+        self.scan = self.pos
+
+        if line ~= '' then
+            local e, me, err = brass.decode(line, self.opts)
+            if err == nil and me <= #line then
+                me, err = select(2, fail(line, me, unexpected_character, 'unexpected data after expression', 'end of line'))
+            end
+            if err ~= nil then
+                return nil, err
+            end
+            return e, nil
+        end
+    end
+end
+
+-- creates a symbol atom; name must start with a letter or '_' followed by letters, digits, '_', '-', '.' or '/':
+function brass.symbol(name)
+    return { __brass_kind = 'symbol', name = name }
+end
+
+-- wraps a number so that it is always encoded as a float atom even if it is integral:
+function brass.float(x)
+    return { __brass_kind = 'float', value = x }
+end
+
+-- encoding error objects have the same fields as decoding errors except for the position and excerpt; path is the
+-- path of the value which could not be encoded:
+local encode_error = {}
+encode_error.__index = encode_error
+
+function encode_error:__tostring()
+    local t = { self.kind, ': ', self.err }
+    if self.path ~= '' then
+        t[#t+1] = ' in ' .. self.path
+    end
+    return table.concat(t)
+end
+
+-- the encoding error kind of integral numbers beyond max_integer which matches brass.ErrIntegerRange in Go:
+local integer_range = 'integer exceeds 52 bits'
+
+local function encode_fail(kind, err)
+    return setmetatable({ kind = kind, err = err, path = '' }, encode_error)
+end
+
+local function escape_char(m)
+    local b = string.byte(m)
+    if b == 9 then
+        return '\\t'
+    elseif b == 10 then
+        return '\\n'
+    elseif b == 13 then
+        return '\\r'
+    elseif b == 34 then
+        return '\\"'
+    elseif b == 92 then
+        return '\\\\'
+    elseif b < 32 or b >= 128 then
+        return string.format('\\x%02x', b)
+    else
+        return m
+    end
+end
+
+-- formats a non-negative integer up to max_integer in hex without exceeding the range of string.format's %x on
+-- Lua 5.1:
+local function integer_hex(x)
+    if x < 0x10000000 then
+        return string.format('%x', x)
+    end
+    return string.format('%x%07x', math.floor(x / 0x10000000), x % 0x10000000)
+end
+
+-- orders map keys as the Go implementation does: first by kind and then by value:
+local kind_order = { ['nil'] = 0, bool = 1, integer = 2, string = 4, octets = 5, symbol = 9, float = 10 }
+
+local function compare_keys(a, b)
+    if a.kind ~= b.kind then
+        return kind_order[a.kind] < kind_order[b.kind]
+    end
+    if a.kind == 'float' then
+        -- floats are ordered by their bits as signed integers:
+        local an, bn = a.value >= '8', b.value >= '8'
+        if an ~= bn then
+            return an
+        end
+    elseif a.kind == 'bool' then
+        -- true is stored as -1:
+        return a.value and not b.value
+    end
+    return a.value < b.value
+end
+
+local encode_value
+
+-- encodes a map key into out and returns its kind and value for sorting:
+local function encode_key(k, opts, out)
+    local t = type(k)
+    local kind = t == 'table' and kind_of(k) or nil
+    if k == brass.null or kind == 'nil' then
+        out[#out+1] = 'nil'
+        return 'nil', 0
+    elseif t == 'boolean' then
+        out[#out+1] = tostring(k)
+        return 'bool', k
+    elseif t == 'string' then
+        encode_value(k, nil, nil, out)
+        return 'string', k
+    elseif t == 'number' or kind == 'float' then
+        if k ~= k then
+            return nil, encode_fail(unrepresentable, 'NaN map keys are not supported')
+        end
+        local n = #out
+        local err = encode_value(k, opts, nil, out)
+        if err ~= nil then
+            return nil, err
+        end
+        local x = out[n+1]
+        if x:sub(1, 1) == '%' then
+            return 'float', x:sub(2)
+        end
+        return 'integer', k
+    elseif kind == 'octets' then
+        local err = encode_value(k, nil, nil, out)
+        if err ~= nil then
+            return nil, err
+        end
+        return 'octets', octets_data(k)
+    elseif kind == 'symbol' then
+        local err = encode_value(k, nil, nil, out)
+        if err ~= nil then
+            return nil, err
+        end
+        return 'symbol', k.name
+    end
+    return nil, encode_fail(not_primitive, 'map key must be a primitive, got ' .. t)
+end
+
+-- encodes e by appending strings to out. active holds the tables being encoded to detect cycles. returns an
+-- error object if e cannot be encoded:
+encode_value = function (e, opts, active, out)
+    local t = type(e)
     if e == nil then
-        return 'nil'
-    elseif e == true then
-        return 'true'
-    elseif e == false then
-        return 'false'
-    elseif type(e) == 'string' then
-        local s = e
+        out[#out+1] = 'nil'
+    elseif t == 'boolean' then
+        out[#out+1] = tostring(e)
+    elseif t == 'string' then
         -- escape characters:
-        return '"' .. s:gsub('[^%w ]', function (m)
-            local b = string.byte(m)
-            if b == 9 then
-                return '\\t'
-            elseif b == 10 then
-                return '\\n'
-            elseif b == 13 then
-                return '\\r'
-            elseif b == 34 then
-                return '\\"'
-            elseif b == 92 then
-                return '\\\\'
-            elseif b < 32 or b >= 128 then
-                return string.format('\\x%02x', b)
+        out[#out+1] = '"' .. e:gsub('[^%w ]', escape_char) .. '"'
+    elseif t == 'number' then
+        if native_integers and math.type(e) == 'integer' then
+            if (e > max_integer or e < -max_integer) and not (opts ~= nil and opts.wide_integers) then
+                return encode_fail(integer_range, 'integer exceeds 52 bits; use the wide_integers option to encode it')
+            elseif e == math.mininteger then
+                out[#out+1] = '-$8000000000000000'
+            elseif e < 0 then
+                out[#out+1] = string.format('-$%x', -e)
             else
-                return m
+                out[#out+1] = string.format('$%x', e)
             end
-        end) .. '"'
-    elseif type(e) == 'number' then
-        if e < 0 then
-            return string.format('-$%x', -e)
+        elseif native_integers or e ~= e or e ~= math.floor(e) or e == inf or e == -inf then
+            out[#out+1] = '%' .. float_to_hex(e)
+        elseif e > max_integer or e < -max_integer then
+            return encode_fail(integer_range, 'integral number exceeds 52 bits; use brass.float to encode it as a float')
+        elseif e < 0 then
+            out[#out+1] = '-$' .. integer_hex(-e)
         else
-            return string.format('$%x', e)
+            out[#out+1] = '$' .. integer_hex(e)
         end
-    elseif type(e) == 'table' then
-        if e.__brass_kind == 'nil' then
-            return 'nil'
-        elseif e.__brass_kind == 'list' then
-            local l = {}
-            for i=1,#e do
-                l[#l+1] = brass.encode(e[i])
-                l[#l+1] = ' '
+    elseif t == 'table' then
+        local kind = kind_of(e)
+        if kind == 'nil' then
+            out[#out+1] = 'nil'
+        elseif kind == 'symbol' then
+            -- nil, true and false are keywords rather than symbols:
+            if type(e.name) ~= 'string' or e.name:match('^[A-Za-z_][A-Za-z0-9_%-%./]*$') == nil
+                or e.name == 'nil' or e.name == 'true' or e.name == 'false' then
+                return encode_fail('invalid symbol', string.format('invalid symbol %q', tostring(e.name)))
             end
-            if #l > 0 then
-                l[#l] = nil
+            out[#out+1] = e.name
+        elseif kind == 'float' then
+            if type(e.value) ~= 'number' then
+                return encode_fail(unrepresentable, 'float value must be a number')
             end
-            return '(' .. table.concat(l) .. ')'
-        elseif e.__brass_kind == 'octets' then
-            local l = {}
-            for i=1,#e do
-                l[#l+1] = string.format('%02x', e[i])
+            out[#out+1] = '%' .. float_to_hex(e.value)
+        elseif kind == 'octets' then
+            local data = octets_data(e)
+            out[#out+1] = '#' .. integer_hex(#data) .. '$' .. tohex(data)
+        elseif kind == 'list' or kind == 'map' then
+            if active[e] then
+                return encode_fail(unrepresentable, 'cyclic table')
             end
-            return '#' .. string.format('%x', #e) .. '$' .. table.concat(l)
-        elseif e.__brass_kind == 'map' then
-            local l = {}
-            for k,v in pairs(e) do
-                if k ~= '__brass_kind' then
-                    l[#l+1] = '('
-                    l[#l+1] = brass.encode(k)
-                    l[#l+1] = ' '
-                    l[#l+1] = brass.encode(v)
-                    l[#l+1] = ')'
+            active[e] = true
+
+            if kind == 'list' then
+                out[#out+1] = '('
+                for i=1,#e do
+                    if i > 1 then
+                        out[#out+1] = ' '
+                    end
+                    local err = encode_value(e[i], opts, active, out)
+                    if err ~= nil then
+                        return at_path(err, i-1)
+                    end
+                end
+                out[#out+1] = ')'
+            else
+                local entries = {}
+                -- the __brass_kind field marks maps which were not made by brass.map:
+                local marked = getmetatable(e) == map_mt
+                for k,v in pairs(e) do
+                    if marked or k ~= '__brass_kind' then
+                        local key = {}
+                        local kkind, kvalue = encode_key(k, opts, key)
+                        if kkind == nil then
+                            return kvalue
+                        end
+
+                        local en = { kind = kkind, value = kvalue, '(', key[1], ' ' }
+                        local err = encode_value(v, opts, active, en)
+                        if err ~= nil then
+                            return at_path(err, key[1])
+                        end
+                        en[#en+1] = ')'
+                        entries[#entries+1] = en
+                    end
+                end
+
+                if opts ~= nil and opts.sorted then
+                    table.sort(entries, compare_keys)
+                end
+
+                out[#out+1] = '{'
+                for i, en in ipairs(entries) do
+                    if i > 1 then
+                        out[#out+1] = ' '
+                    end
+                    out[#out+1] = table.concat(en)
                 end
+                out[#out+1] = '}'
             end
-            return '{' .. table.concat(l, ' ') .. '}'
+
+            active[e] = nil
+        else
+            return encode_fail(unrepresentable, 'table without a known __brass_kind')
         end
+    else
+        return encode_fail(unrepresentable, 'unsupported type ' .. t)
+    end
+    return nil
+end
+
+-- encodes e and returns its encoding or nil and an error object if e cannot be encoded. strings, numbers, booleans
+-- and the tables produced by the decoder and the constructors above may be encoded; functions, userdata, threads,
+-- tables without a __brass_kind and cyclic tables cannot. numbers are encoded as described at the top of this file.
+-- opts is an optional table of options:
+--   sorted = true encodes map entries in ascending key order as the Go implementation orders them so that equal
+--                 maps have equal encodings
+--   wide_integers = true encodes integers beyond 52 bits on Lua 5.3 and later as 64-bit integer atoms which the Go
+--                        decoder accepts but implementations with only double numbers do not
+function brass.encode(e, opts)
+    local out = {}
+    local err = encode_value(e, opts, {}, out)
+    if err ~= nil then
+        return nil, err
     end
+    return table.concat(out)
 end
 
 return brass
@@ -2,4 +2,9 @@ module lua
 
 go 1.19
 
-require github.com/yuin/gopher-lua v1.1.0
+require (
+	github.com/alttpo/brass v0.0.0-00010101000000-000000000000
+	github.com/yuin/gopher-lua v1.1.0
+)
+
+replace github.com/alttpo/brass => ../
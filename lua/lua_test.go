@@ -2,10 +2,15 @@ package lua
 
 import (
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"github.com/alttpo/brass"
 	lua "github.com/yuin/gopher-lua"
+	"io"
+	"math"
 	"math/rand"
 	"reflect"
+	"strconv"
 	"strings"
 	"testing"
 )
@@ -24,6 +29,43 @@ func TestLuaDecoder(t *testing.T) {
 			wantErr: "",
 			wantN:   mkList(mkNil(), lua.LTrue, lua.LFalse),
 		},
+		{
+			name:    "(read write-mem a.b/c_D9 nilx)",
+			nstr:    "(read write-mem a.b/c_D9 nilx)",
+			wantErr: "",
+			wantN:   mkList(mkSymbol("read"), mkSymbol("write-mem"), mkSymbol("a.b/c_D9"), mkSymbol("nilx")),
+		},
+		{
+			name:    "floats",
+			nstr:    "(%3ff8000000000000 %bff0000000000000 %7ff0000000000000 %fff0000000000000 %0000000000000001 %7fefffffffffffff)",
+			wantErr: "",
+			wantN: mkList(
+				lua.LNumber(1.5),
+				lua.LNumber(-1),
+				lua.LNumber(math.Inf(1)),
+				lua.LNumber(math.Inf(-1)),
+				lua.LNumber(math.SmallestNonzeroFloat64),
+				lua.LNumber(math.MaxFloat64),
+			),
+		},
+		{
+			name:    "(%3ff800000000000)",
+			nstr:    "(%3ff800000000000)",
+			wantErr: "float must have exactly 16 hex digits",
+			wantN:   mkList(),
+		},
+		{
+			name:    "(%7ff8000000000001)",
+			nstr:    "(%7ff8000000000001)",
+			wantErr: "non-canonical NaN float encoding",
+			wantN:   mkList(),
+		},
+		{
+			name:    "(9ab)",
+			nstr:    "(9ab)",
+			wantErr: "unrecognized brass s-expression",
+			wantN:   mkList(),
+		},
 		{
 			name:    "($1 $2 -$3 -$4)",
 			nstr:    "($1 $2 -$3 -$4)",
@@ -83,6 +125,12 @@ func TestLuaDecoder(t *testing.T) {
 			wantErr: "",
 			wantN:   mkList(mkString("cb\x61\r\n\tq")),
 		},
+		{
+			name:    "(#2$6g)",
+			nstr:    "(#2$6g)",
+			wantErr: "invalid hex-octet digit",
+			wantN:   mkList(),
+		},
 		func() test {
 			// fill a buffer with random bytes:
 			large := make([]byte, 256)
@@ -190,6 +238,14 @@ func mkString(s string) lua.LValue {
 }
 
 func mkOctets(s []byte) lua.LValue {
+	t := table()
+	t.RawSetString("__brass_kind", lua.LString("octets"))
+	t.RawSetString("data", lua.LString(s))
+	return t
+}
+
+// mkOctetsTable makes octets in the table form decoded with the 'table' octets option.
+func mkOctetsTable(s []byte) lua.LValue {
 	t := table()
 	t.RawSetString("__brass_kind", lua.LString("octets"))
 	for _, b := range s {
@@ -198,6 +254,20 @@ func mkOctets(s []byte) lua.LValue {
 	return t
 }
 
+func mkSymbol(name string) lua.LValue {
+	t := table()
+	t.RawSetString("__brass_kind", lua.LString("symbol"))
+	t.RawSetString("name", lua.LString(name))
+	return t
+}
+
+func mkFloat(f float64) lua.LValue {
+	t := table()
+	t.RawSetString("__brass_kind", lua.LString("float"))
+	t.RawSetString("value", lua.LNumber(f))
+	return t
+}
+
 func mkNil() lua.LValue {
 	t := table()
 	t.RawSetString("__brass_kind", lua.LString("nil"))
@@ -257,6 +327,28 @@ func TestEncoder(t *testing.T) {
 			wantErr: "",
 			e:       mkList(mkNil(), lua.LTrue, lua.LFalse),
 		},
+		{
+			name:    "floats",
+			wantN:   "(%3ff8000000000000 %bfb999999999999a %7ff0000000000000 %fff0000000000000 %7ff8000000000000 %0000000000000001 %000fffffffffffff %3ff0000000000000 $1)",
+			wantErr: "",
+			e: mkList(
+				lua.LNumber(1.5),
+				lua.LNumber(-0.1),
+				lua.LNumber(math.Inf(1)),
+				lua.LNumber(math.Inf(-1)),
+				lua.LNumber(math.NaN()),
+				lua.LNumber(math.SmallestNonzeroFloat64),
+				lua.LNumber(math.Float64frombits(0x000fffffffffffff)),
+				mkFloat(1),
+				lua.LNumber(1),
+			),
+		},
+		{
+			name:    "(read write-mem)",
+			wantN:   "(read write-mem)",
+			wantErr: "",
+			e:       mkList(mkSymbol("read"), mkSymbol("write-mem")),
+		},
 		{
 			name:    "($1 $2 -$3 -$4)",
 			wantN:   "($1 $2 -$3 -$4)",
@@ -280,6 +372,12 @@ func TestEncoder(t *testing.T) {
 			wantErr: "",
 			e:       mkList(mkOctets([]byte("a"))),
 		},
+		{
+			name:    "(#2$61ff #0$) table form",
+			wantN:   "(#2$61ff #0$)",
+			wantErr: "",
+			e:       mkList(mkOctetsTable([]byte("a\xff")), mkOctetsTable([]byte{})),
+		},
 		{
 			name:    `("")`,
 			wantN:   `("")`,
@@ -368,3 +466,739 @@ func TestEncoder(t *testing.T) {
 		})
 	}
 }
+
+// loadBrass loads brass.lua and returns its module table.
+func loadBrass(t *testing.T, l *lua.LState) lua.LValue {
+	t.Helper()
+
+	rfn, err := l.LoadFile("brass.lua")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = l.CallByParam(lua.P{
+		Fn:      rfn,
+		NRet:    1,
+		Protect: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	br := l.Get(-1)
+	l.Pop(1)
+	return br
+}
+
+// toSExpr converts a value decoded by brass.lua to the equivalent Go expression.
+func toSExpr(t *testing.T, v lua.LValue) *brass.SExpr {
+	t.Helper()
+
+	switch x := v.(type) {
+	case lua.LBool:
+		return brass.MakeBool(bool(x))
+	case lua.LNumber:
+		f := float64(x)
+		if f == math.Trunc(f) && math.Abs(f) <= brass.MaxInteger {
+			return brass.MakeInt64(int64(f))
+		}
+		return brass.MakeFloat64(f)
+	case lua.LString:
+		return brass.MakeString(string(x))
+	case *lua.LTable:
+		// maps made by brass.map have the kind in their metatable and may have a __brass_kind entry:
+		kind, marked := x.RawGetString("__brass_kind").String(), false
+		if mt, ok := x.Metatable.(*lua.LTable); ok {
+			if index, ok := mt.RawGetString("__index").(*lua.LTable); ok {
+				kind, marked = index.RawGetString("__brass_kind").String(), true
+			}
+		}
+		switch kind {
+		case "nil":
+			return brass.MakeNil()
+		case "symbol":
+			return brass.MakeSymbol(x.RawGetString("name").String())
+		case "float":
+			return brass.MakeFloat64(float64(x.RawGetString("value").(lua.LNumber)))
+		case "octets":
+			if data, ok := x.RawGetString("data").(lua.LString); ok {
+				return brass.MakeOctets([]byte(data))
+			}
+			b := make([]byte, x.Len())
+			for i := range b {
+				b[i] = byte(x.RawGetInt(i + 1).(lua.LNumber))
+			}
+			return brass.MakeOctets(b)
+		case "list":
+			list := make([]*brass.SExpr, x.Len())
+			for i := range list {
+				list[i] = toSExpr(t, x.RawGetInt(i+1))
+			}
+			return brass.MakeList(list)
+		case "map":
+			dict := make(map[brass.SExprPrimitive]*brass.SExpr)
+			x.ForEach(func(k lua.LValue, v lua.LValue) {
+				if !marked && k == lua.LString("__brass_kind") {
+					return
+				}
+				p, err := toSExpr(t, k).Primitive()
+				if err != nil {
+					t.Fatalf("map key %s: %v", fmtLua(k), err)
+				}
+				dict[p] = toSExpr(t, v)
+			})
+			return brass.MakeMap(dict)
+		default:
+			t.Fatalf("unexpected __brass_kind %q", kind)
+		}
+	}
+
+	t.Fatalf("unexpected lua value %s", fmtLua(v))
+	return nil
+}
+
+func TestLuaDecoder_Maps(t *testing.T) {
+	var cases = []struct {
+		name    string
+		nstr    string
+		wantErr string
+	}{
+		{name: "empty", nstr: `({})`},
+		{name: "string keys", nstr: `({("a" $1) ("b" ("x" y))} {("nested" {("c" nil)})})`},
+		{name: "primitive keys", nstr: `({($1 "one") (-$2 "minus two") (%3ff8000000000000 "float") (sym "symbol")})`},
+		{name: "nil and boolean keys", nstr: `({(nil $0) (true $1) (false $2)})`},
+		{name: "kind key", nstr: `({("__brass_kind" "list") ("a" $1)} {("__brass_kind" {("__brass_kind" nil)})})`},
+		{name: "octets keys", nstr: `({(#2$0102 "a") (#0$ "b") (#1$61 #1$62)})`},
+		{name: "whitespace", nstr: `( {  ( "a" $1)  ("b"  $2) } )`},
+		{name: "duplicate keys", nstr: `({("a" $1) ("a" $2)})`},
+		{name: "list key", nstr: `({(($1) $1)})`, wantErr: "map key must be a primitive"},
+		{name: "map key", nstr: `({({} $1)})`, wantErr: "map key must be a primitive"},
+		{name: "not an entry", nstr: `({"a" $1})`, wantErr: "expected map entry"},
+		{name: "unterminated entry", nstr: `({("a" $1 $2)})`, wantErr: "expected end of map entry"},
+		{name: "unterminated map", nstr: `({("a" $1)`, wantErr: "unexpected end of map"},
+	}
+
+	l := lua.NewState()
+	defer l.Close()
+	decode := l.GetField(loadBrass(t, l), "decode").(*lua.LFunction)
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			err := l.CallByParam(lua.P{Fn: decode, NRet: 3, Protect: true}, lua.LString(tt.nstr))
+			if err != nil {
+				t.Fatalf("glua error: %v", err)
+			}
+			n, perr := l.Get(-3), l.Get(-1)
+			l.Pop(3)
+
+			// the Go decoder must agree:
+			want, gerr := brass.NewDecoder(strings.NewReader(tt.nstr)).Decode()
+
+			if tt.wantErr != "" {
+				if perr == lua.LNil {
+					t.Fatalf("want err='%v' got none", tt.wantErr)
+				}
+				if got := perr.(*lua.LTable).RawGetString("err").String(); got != tt.wantErr {
+					t.Fatalf("want err='%v' got '%v'", tt.wantErr, got)
+				}
+				if gerr == nil {
+					t.Fatalf("Go decoder accepted %s", tt.nstr)
+				}
+				return
+			}
+			if perr != lua.LNil {
+				t.Fatalf("unexpected err %s", fmtLua(perr))
+			}
+			if gerr != nil {
+				t.Fatal(gerr)
+			}
+
+			if got := toSExpr(t, n); !reflect.DeepEqual(got, want) {
+				t.Fatalf("want %s\ngot  %s", want, got)
+			}
+		})
+	}
+}
+
+func TestLuaDecoder_MapKeys(t *testing.T) {
+	l := lua.NewState()
+	defer l.Close()
+	l.SetGlobal("brass", loadBrass(t, l))
+
+	// keys must be found with the values Lua scripts use to look them up:
+	err := l.DoString(`
+		local e, _, err = brass.decode('({(nil $1) (true $2) (false $3) (sym $4) (#2$6162 $5) ("s" $6) ($7 $7)})')
+		assert(err == nil, err and err.err)
+		local m = e[1]
+		assert(m[brass.null] == 1, 'nil key')
+		assert(m[true] == 2, 'true key')
+		assert(m[false] == 3, 'false key')
+		assert(m[brass.symbol_key('sym')] == 4, 'symbol key')
+		assert(m[brass.octets_key('ab')] == 5, 'octets key')
+		assert(m.s == 6, 'string key')
+		assert(m[7] == 7, 'integer key')
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEncoder_Maps(t *testing.T) {
+	l := lua.NewState()
+	defer l.Close()
+	l.SetGlobal("brass", loadBrass(t, l))
+
+	err := l.DoString(`
+		local m = { __brass_kind = 'map', a = 1, [true] = 'yes' }
+		m[brass.null] = brass.symbol('x')
+		m[brass.octets_key('\1\2')] = { __brass_kind = 'list', 1, 2 }
+		m[brass.symbol_key('k')] = { __brass_kind = 'map', nested = false }
+		encoded = brass.encode({ __brass_kind = 'list', m })
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := brass.NewDecoder(strings.NewReader(l.GetGlobal("encoded").String())).Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := brass.MakeList([]*brass.SExpr{brass.MakeMap(map[brass.SExprPrimitive]*brass.SExpr{
+		brass.PrimitiveString("a"):                       brass.MakeInt64(1),
+		mustPrimitive(t, brass.MakeBool(true)):           brass.MakeString("yes"),
+		mustPrimitive(t, brass.MakeNil()):                brass.MakeSymbol("x"),
+		mustPrimitive(t, brass.MakeOctets([]byte{1, 2})): brass.MakeList([]*brass.SExpr{brass.MakeInt64(1), brass.MakeInt64(2)}),
+		mustPrimitive(t, brass.MakeSymbol("k")): brass.MakeMap(map[brass.SExprPrimitive]*brass.SExpr{
+			brass.PrimitiveString("nested"): brass.MakeBool(false),
+		}),
+	})})
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("want %s\ngot  %s", want, got)
+	}
+}
+
+func TestEncoder_MapKindKey(t *testing.T) {
+	l := lua.NewState()
+	defer l.Close()
+	l.SetGlobal("brass", loadBrass(t, l))
+
+	// a decoded map with a __brass_kind key remains a map and encodes the key as an entry:
+	err := l.DoString(`
+		local e, _, err = brass.decode('({("__brass_kind" "list")})')
+		assert(err == nil, err and err.err)
+		assert(e[1].__brass_kind == 'list', 'entry')
+		encoded, err = brass.encode(e)
+		assert(err == nil, err and err.err)
+		made = brass.encode(brass.map({ __brass_kind = 'nil', a = 1 }), { sorted = true })
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := l.GetGlobal("encoded").String(), `({("__brass_kind" "list")})`; got != want {
+		t.Errorf("want %s\ngot  %s", want, got)
+	}
+	if got, want := l.GetGlobal("made").String(), `{("__brass_kind" "nil") ("a" $1)}`; got != want {
+		t.Errorf("want %s\ngot  %s", want, got)
+	}
+}
+
+func mustPrimitive(t *testing.T, e *brass.SExpr) brass.SExprPrimitive {
+	t.Helper()
+	p, err := e.Primitive()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestLuaStream(t *testing.T) {
+	l := lua.NewState()
+	defer l.Close()
+	l.SetGlobal("brass", loadBrass(t, l))
+
+	// run feeds input to a stream in chunks of size n and returns the encoded expressions or errors of each frame
+	// along with the number of bytes left buffered:
+	err := l.DoString(`
+		function run(input, n)
+			local s = brass.stream()
+			local out = {}
+			for i = 1, #input, n do
+				s:feed(input:sub(i, i + n - 1))
+				while true do
+					local e, err = s:next()
+					if err ~= nil then
+						out[#out+1] = 'error: ' .. err.err
+					elseif e == nil then
+						break
+					else
+						out[#out+1] = brass.encode(e)
+					end
+				end
+			end
+			return table.concat(out, '|'), s:buffered()
+		end
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	input := "(read $10 \"a\\nb\")\n\r\n  ($1 ($2 #2$0a0b))  \r\n($1\nx ($2)\n(\"unterminated)\n(%3ff8000000000000) $3\n(tail"
+	want := `(read $10 "a\nb")|($1 ($2 #2$0a0b))|error: unexpected end of list|error: expected list|` +
+		`error: unterminated string literal|error: unexpected data after expression`
+
+	for n := 1; n <= len(input); n++ {
+		err = l.CallByParam(lua.P{Fn: l.GetGlobal("run"), NRet: 2, Protect: true}, lua.LString(input), lua.LNumber(n))
+		if err != nil {
+			t.Fatalf("glua error: %v", err)
+		}
+		got, buffered := l.Get(-2).String(), l.Get(-1)
+		l.Pop(2)
+
+		if got != want {
+			t.Fatalf("chunk size %d: want %s\ngot  %s", n, want, got)
+		}
+		if buffered != lua.LNumber(len("(tail")) {
+			t.Fatalf("chunk size %d: want %d bytes buffered, got %v", n, len("(tail"), buffered)
+		}
+	}
+}
+
+func TestLuaOctets(t *testing.T) {
+	// larger than the chunks converted at a time:
+	huge := make([]byte, 10000)
+	rand.Read(huge)
+	nstr := fmt.Sprintf("(#%x$%s #2$00ff)", len(huge), hex.EncodeToString(huge))
+
+	l := lua.NewState()
+	defer l.Close()
+	br := loadBrass(t, l)
+	decode := l.GetField(br, "decode").(*lua.LFunction)
+	encode := l.GetField(br, "encode").(*lua.LFunction)
+
+	for _, tt := range []struct {
+		name  string
+		opts  lua.LValue
+		wantN lua.LValue
+	}{
+		{name: "binary string", opts: lua.LNil, wantN: mkList(mkOctets(huge), mkOctets([]byte{0, 0xff}))},
+		{name: "table", opts: mkOctetsOption("table"), wantN: mkList(mkOctetsTable(huge), mkOctetsTable([]byte{0, 0xff}))},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			err := l.CallByParam(lua.P{Fn: decode, NRet: 3, Protect: true}, lua.LString(nstr), tt.opts)
+			if err != nil {
+				t.Fatalf("glua error: %v", err)
+			}
+			n, perr := l.Get(-3), l.Get(-1)
+			l.Pop(3)
+			if perr != lua.LNil {
+				t.Fatalf("unexpected err %s", fmtLua(perr))
+			}
+			if !reflect.DeepEqual(tt.wantN, n) {
+				t.Fatal("decoded octets differ")
+			}
+
+			// both forms encode the same:
+			err = l.CallByParam(lua.P{Fn: encode, NRet: 1, Protect: true}, n)
+			if err != nil {
+				t.Fatalf("glua error: %v", err)
+			}
+			got := l.Get(-1).String()
+			l.Pop(1)
+			if got != nstr {
+				t.Fatal("encoded octets differ")
+			}
+		})
+	}
+}
+
+func mkOctetsOption(form string) lua.LValue {
+	t := table()
+	t.RawSetString("octets", lua.LString(form))
+	return t
+}
+
+func TestLuaDecoder_Errors(t *testing.T) {
+	// the Go errors corresponding to each error kind:
+	kinds := map[string][]error{
+		"unexpected character":             {brass.ErrUnexpectedCharacter},
+		"unexpected EOF":                   {io.EOF, io.ErrUnexpectedEOF},
+		"unexpected primitive type":        {brass.ErrNotPrimitive},
+		"non-canonical NaN float encoding": {brass.ErrNonCanonicalFloat},
+		"value out of range":               {strconv.ErrRange},
+	}
+
+	var cases = []struct {
+		nstr     string
+		kind     string
+		err      string
+		pos      int
+		expected string
+		path     string
+		// goAccepts is set where the Go decoder is more lenient:
+		goAccepts bool
+	}{
+		{nstr: `($1`, kind: "unexpected EOF", err: "unexpected end of list", pos: 4, expected: `')'`},
+		{nstr: `($1 ($2`, kind: "unexpected EOF", err: "unexpected end of list", pos: 8, expected: `')'`, path: `[1]`},
+		{nstr: `({("a" $1`, kind: "unexpected EOF", err: "expected end of map entry", pos: 10, expected: `')'`, path: `[0]["a"]`},
+		{nstr: `({("a" `, kind: "unexpected EOF", err: "unexpected end of input", pos: 8, expected: `an expression`, path: `[0]["a"]`},
+		{nstr: `("abc`, kind: "unexpected EOF", err: "unterminated string literal", pos: 6, expected: `'"'`, path: `[0]`},
+		{nstr: `(#3$61)`, kind: "unexpected character", err: "invalid hex-octet digit", pos: 7, expected: `hex digit`, path: `[0]`},
+		{nstr: `(#3$6162`, kind: "unexpected EOF", err: "hex-octet sequence length incorrect", pos: 9, expected: `hex digit`, path: `[0]`},
+		{nstr: `(%12)`, kind: "unexpected character", err: "float must have exactly 16 hex digits", pos: 5, expected: `16 hex digits`, path: `[0]`},
+		{nstr: `($1 %7ff8000000000001)`, kind: "non-canonical NaN float encoding", err: "non-canonical NaN float encoding", pos: 5, expected: `%7ff8000000000000`, path: `[1]`},
+		{nstr: `({(($1) $1)})`, kind: "unexpected primitive type", err: "map key must be a primitive", pos: 4, expected: `a primitive`, path: `[0]`},
+		{nstr: `({"a"})`, kind: "unexpected character", err: "expected map entry", pos: 3, expected: `'(' or '}'`, path: `[0]`},
+		{nstr: `($1 $10000000000000000)`, kind: "value out of range", err: "integer exceeds 52 bits", pos: 5, expected: `a 52-bit integer`, path: `[1]`},
+		{nstr: `(9)`, kind: "unexpected character", err: "unrecognized brass s-expression", pos: 2, expected: `an expression`, path: `[0]`},
+		{nstr: `("\q")`, kind: "unexpected character", err: "invalid escape sequence", pos: 4, path: `[0]`, goAccepts: true},
+		{nstr: `{}`, kind: "unexpected character", err: "expected list", pos: 1, expected: `'('`},
+		{nstr: `({("a" $1 $2)})`, kind: "unexpected character", err: "expected end of map entry", pos: 10, expected: `')'`, path: `[0]["a"]`},
+		{nstr: `($1 {(sym (x %1))})`, kind: "unexpected character", err: "float must have exactly 16 hex digits", pos: 16, expected: `16 hex digits`, path: `[1][sym][1]`},
+	}
+
+	l := lua.NewState()
+	defer l.Close()
+	decode := l.GetField(loadBrass(t, l), "decode").(*lua.LFunction)
+
+	for _, tt := range cases {
+		t.Run(tt.nstr, func(t *testing.T) {
+			err := l.CallByParam(lua.P{Fn: decode, NRet: 3, Protect: true}, lua.LString(tt.nstr))
+			if err != nil {
+				t.Fatalf("glua error: %v", err)
+			}
+			i, perr := l.Get(-2), l.Get(-1)
+			l.Pop(3)
+
+			e, ok := perr.(*lua.LTable)
+			if !ok {
+				t.Fatalf("want error, got %s", fmtLua(perr))
+			}
+			if got := e.RawGetString("kind").String(); got != tt.kind {
+				t.Errorf("want kind '%v' got '%v'", tt.kind, got)
+			}
+			if got := e.RawGetString("err").String(); got != tt.err {
+				t.Errorf("want err '%v' got '%v'", tt.err, got)
+			}
+			if got := e.RawGetString("pos"); got != lua.LNumber(tt.pos) || i != got {
+				t.Errorf("want pos %v got %v and %v", tt.pos, got, i)
+			}
+			if tt.expected != "" {
+				if got := e.RawGetString("expected").String(); got != tt.expected {
+					t.Errorf("want expected '%v' got '%v'", tt.expected, got)
+				}
+			}
+			if got := e.RawGetString("path").String(); got != tt.path {
+				t.Errorf("want path '%v' got '%v'", tt.path, got)
+			}
+			from, to := tt.pos-17, tt.pos+16
+			if from < 0 {
+				from = 0
+			}
+			if to > len(tt.nstr) {
+				to = len(tt.nstr)
+			}
+			if got, want := e.RawGetString("excerpt").String(), tt.nstr[from:to]; got != want {
+				t.Errorf("want excerpt '%v' got '%v'", want, got)
+			}
+
+			// the Go decoder must fail in the same category, except that it drops unknown string escapes:
+			_, gerr := brass.NewDecoder(strings.NewReader(tt.nstr)).Decode()
+			if tt.goAccepts {
+				if gerr != nil {
+					t.Errorf("Go decoder error = %v, want nil", gerr)
+				}
+				return
+			}
+			matched := false
+			for _, want := range kinds[tt.kind] {
+				matched = matched || errors.Is(gerr, want)
+			}
+			if !matched {
+				t.Errorf("Go decoder error = %v, want kind %v", gerr, tt.kind)
+			}
+		})
+	}
+
+	// errors describe themselves when converted to strings:
+	l.SetGlobal("decode", decode)
+	err := l.DoString(`
+		local _, _, err = decode('({("a" $1 $2)})')
+		msg = tostring(err)
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `unexpected character: expected end of map entry at position 10, expected ')' in [0]["a"] near "({(\"a\" $1 $2)})"`
+	if got := l.GetGlobal("msg").String(); got != want {
+		t.Errorf("want %s\ngot  %s", want, got)
+	}
+}
+
+func TestEncoder_Errors(t *testing.T) {
+	l := lua.NewState()
+	defer l.Close()
+	l.SetGlobal("brass", loadBrass(t, l))
+	l.SetGlobal("ud", l.NewUserData())
+
+	var cases = []struct {
+		name string
+		expr string
+		kind string
+		err  string
+		path string
+	}{
+		{name: "function", expr: `{ __brass_kind = 'list', 1, print }`, kind: "value cannot be represented", err: "unsupported type function", path: "[1]"},
+		{name: "userdata", expr: `ud`, kind: "value cannot be represented", err: "unsupported type userdata"},
+		{name: "unknown table", expr: `{ __brass_kind = 'list', { 1, 2 } }`, kind: "value cannot be represented", err: "table without a known __brass_kind", path: "[0]"},
+		{name: "list cycle", expr: `(function () local l = { __brass_kind = 'list', 1 }; l[2] = { __brass_kind = 'list', l }; return l end)()`, kind: "value cannot be represented", err: "cyclic table", path: "[1][0]"},
+		{name: "map cycle", expr: `(function () local m = { __brass_kind = 'map' }; m.self = m; return m end)()`, kind: "value cannot be represented", err: "cyclic table", path: `["self"]`},
+		{name: "invalid symbol", expr: `brass.symbol('1a')`, kind: "invalid symbol", err: `invalid symbol "1a"`},
+		{name: "nil symbol", expr: `brass.symbol('nil')`, kind: "invalid symbol", err: `invalid symbol "nil"`},
+		{name: "true symbol", expr: `{ __brass_kind = 'list', brass.symbol('true') }`, kind: "invalid symbol", err: `invalid symbol "true"`, path: "[0]"},
+		{name: "false symbol", expr: `brass.symbol('false')`, kind: "invalid symbol", err: `invalid symbol "false"`},
+		{name: "integer range", expr: `{ __brass_kind = 'list', 1, 2^52 }`, kind: "integer exceeds 52 bits", err: "integral number exceeds 52 bits; use brass.float to encode it as a float", path: "[1]"},
+		{name: "integer key range", expr: `{ __brass_kind = 'map', [-2^53] = 1 }`, kind: "integer exceeds 52 bits", err: "integral number exceeds 52 bits; use brass.float to encode it as a float"},
+		{name: "list key", expr: `{ __brass_kind = 'map', [{ __brass_kind = 'list' }] = 1 }`, kind: "unexpected primitive type", err: "map key must be a primitive, got table"},
+		{name: "float value", expr: `brass.float('x')`, kind: "value cannot be represented", err: "float value must be a number"},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			err := l.DoString(`s, err = brass.encode(` + tt.expr + `)`)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if s := l.GetGlobal("s"); s != lua.LNil {
+				t.Fatalf("want error, got %s", s)
+			}
+
+			e := l.GetGlobal("err").(*lua.LTable)
+			if got := e.RawGetString("kind").String(); got != tt.kind {
+				t.Errorf("want kind '%v' got '%v'", tt.kind, got)
+			}
+			if got := e.RawGetString("err").String(); got != tt.err {
+				t.Errorf("want err '%v' got '%v'", tt.err, got)
+			}
+			if got := e.RawGetString("path").String(); got != tt.path {
+				t.Errorf("want path '%v' got '%v'", tt.path, got)
+			}
+		})
+	}
+
+	// shared tables which are not cyclic are encoded:
+	err := l.DoString(`
+		local shared = { __brass_kind = 'list', 1 }
+		s, err = brass.encode({ __brass_kind = 'list', shared, shared })
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := l.GetGlobal("s").String(), `(($1) ($1))`; got != want {
+		t.Errorf("want %s got %s", want, got)
+	}
+}
+
+func TestEncoder_IntegerRange(t *testing.T) {
+	l := lua.NewState()
+	defer l.Close()
+	l.SetGlobal("brass", loadBrass(t, l))
+
+	err := l.DoString(`s = brass.encode({ __brass_kind = 'list', 2^40, -(2^33 + 1), 2^52 - 1, -(2^52 - 1), brass.float(2^52), brass.float(-2^53), 1/0 })`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := l.GetGlobal("s").String()
+	if want := `($10000000000 -$200000001 $fffffffffffff -$fffffffffffff %4330000000000000 %c340000000000000 %7ff0000000000000)`; got != want {
+		t.Fatalf("want %s\ngot  %s", want, got)
+	}
+
+	// the Go decoder must agree on the values:
+	e, err := brass.NewDecoder(strings.NewReader(got)).Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := brass.MakeList([]*brass.SExpr{
+		brass.MakeInt64(1 << 40),
+		brass.MakeInt64(-(1<<33 + 1)),
+		brass.MakeInt64(brass.MaxInteger),
+		brass.MakeInt64(brass.MinInteger),
+		brass.MakeFloat64(1 << 52),
+		brass.MakeFloat64(-(1 << 53)),
+		brass.MakeFloat64(math.Inf(1)),
+	})
+	if !reflect.DeepEqual(e, want) {
+		t.Fatalf("want %s\ngot  %s", want, e)
+	}
+}
+
+func TestEncoder_SortedMaps(t *testing.T) {
+	l := lua.NewState()
+	defer l.Close()
+	l.SetGlobal("brass", loadBrass(t, l))
+
+	err := l.DoString(`
+		local m = { __brass_kind = 'map', b = 1, a = 2, [10] = 3, [-1] = 4, [2] = 5, [1.5] = 6, [-2.5] = 7, [-0.5] = 8 }
+		m[true] = 9
+		m[false] = 10
+		m[brass.null] = 11
+		m[brass.symbol_key('z')] = 12
+		m[brass.symbol_key('y')] = 13
+		m[brass.octets_key('\2')] = 14
+		m[brass.octets_key('\1\2')] = 15
+		s = brass.encode({ __brass_kind = 'list', m }, { sorted = true })
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := l.GetGlobal("s").String()
+	want := `({(nil $b) (true $9) (false $a) (-$1 $4) ($2 $5) ($a $3) ("a" $2) ("b" $1) (#2$0102 $f) (#1$02 $e) (y $d) (z $c)` +
+		` (%bfe0000000000000 $8) (%c004000000000000 $7) (%3ff8000000000000 $6)})`
+	if got != want {
+		t.Fatalf("want %s\ngot  %s", want, got)
+	}
+
+	// the order must match the order of the Go implementation:
+	d := brass.NewDecoder(strings.NewReader(got))
+	d.UseOrderedMaps()
+	ordered, err := d.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	unordered, err := brass.NewDecoder(strings.NewReader(got)).Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gotKeys, wantKeys := []string{}, []string{}
+	for _, en := range ordered.AsList()[0].AsOrderedMap().Entries() {
+		gotKeys = append(gotKeys, string(en.Key.AppendBrass(nil)))
+	}
+	for _, en := range unordered.AsList()[0].AsOrderedMap().Entries() {
+		wantKeys = append(wantKeys, string(en.Key.AppendBrass(nil)))
+	}
+	if !reflect.DeepEqual(gotKeys, wantKeys) {
+		t.Fatalf("want %v\ngot  %v", wantKeys, gotKeys)
+	}
+}
+
+func TestLuaIntegers(t *testing.T) {
+	l := lua.NewState()
+	defer l.Close()
+	l.SetGlobal("brass", loadBrass(t, l))
+
+	// without native integers, integers are limited to 52 bits:
+	err := l.DoString(`
+		assert(not brass.native_integers, 'native integers')
+		local e, _, err = brass.decode('($fffffffffffff -$fffffffffffff $00000000000000001)')
+		assert(err == nil, tostring(err))
+		assert(e[1] == 2^52 - 1 and e[2] == -(2^52 - 1) and e[3] == 1, 'decoded integers')
+		_, _, err = brass.decode('($1 $10000000000000)')
+		kind, msg, path = err.kind, err.err, err.path
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := l.GetGlobal("kind").String(), strconv.ErrRange.Error(); got != want {
+		t.Errorf("want kind '%v' got '%v'", want, got)
+	}
+	if got, want := l.GetGlobal("msg").String(), "integer exceeds 52 bits"; got != want {
+		t.Errorf("want err '%v' got '%v'", want, got)
+	}
+	if got := l.GetGlobal("path").String(); got != "[1]" {
+		t.Errorf("want path '[1]' got '%v'", got)
+	}
+
+	// the Go implementation agrees that the integer is beyond the range of the encoding:
+	e, err := brass.NewDecoder(strings.NewReader(`($1 $10000000000000)`)).Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = e.Validate(); !errors.Is(err, brass.ErrIntegerRange) {
+		t.Errorf("Validate() error = %v, want %v", err, brass.ErrIntegerRange)
+	}
+}
+
+func TestLuaIntegers_Native(t *testing.T) {
+	l := lua.NewState()
+	defer l.Close()
+
+	// simulate the integer subtype of Lua 5.3 before loading brass.lua. gopher-lua numbers are doubles so only
+	// integers exactly representable by doubles can be tested; integral numbers listed in floats are treated as
+	// having the float subtype. the 64-bit arithmetic of real Lua 5.3 integers, e.g. the wraparound of tonumber
+	// near 2^63 and the negation of math.mininteger, is not exercised by these tests:
+	err := l.DoString(`
+		floats = {}
+		math.type = function (x)
+			if type(x) ~= 'number' then
+				return nil
+			elseif x ~= math.floor(x) or floats[x] then
+				return 'float'
+			end
+			return 'integer'
+		end
+		math.mininteger = -2^63
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.SetGlobal("brass", loadBrass(t, l))
+
+	err = l.DoString(`
+		assert(brass.native_integers, 'native integers')
+
+		-- integers beyond 52 bits are decoded:
+		local e, _, err = brass.decode('($10000000000000 -$20000000000000 $0000000000000000001)')
+		assert(err == nil, tostring(err))
+		assert(e[1] == 2^52 and e[2] == -2^53 and e[3] == 1, 'decoded integers')
+
+		_, _, err = brass.decode('($10000000000000000)')
+		kind, msg = err.kind, err.err
+
+		-- numbers are encoded by subtype, integers beyond 52 bits only when wide integers are requested:
+		floats[2] = true
+		local list = { __brass_kind = 'list', 2^52 - 1, 2, 1.5, -2^53, math.mininteger }
+		_, err = brass.encode(list)
+		narrow_kind, narrow_path = err.kind, err.path
+		encoded = brass.encode(list, { wide_integers = true })
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := l.GetGlobal("kind").String(), strconv.ErrRange.Error(); got != want {
+		t.Errorf("want kind '%v' got '%v'", want, got)
+	}
+	if got, want := l.GetGlobal("msg").String(), "integer exceeds 64 bits"; got != want {
+		t.Errorf("want err '%v' got '%v'", want, got)
+	}
+
+	if got, want := l.GetGlobal("narrow_kind").String(), brass.ErrIntegerRange.Error(); got != want {
+		t.Errorf("want kind '%v' got '%v'", want, got)
+	}
+	if got, want := l.GetGlobal("narrow_path").String(), "[3]"; got != want {
+		t.Errorf("want path '%v' got '%v'", want, got)
+	}
+
+	got := l.GetGlobal("encoded").String()
+	if want := `($fffffffffffff %4000000000000000 %3ff8000000000000 -$20000000000000 -$8000000000000000)`; got != want {
+		t.Fatalf("want %s\ngot  %s", want, got)
+	}
+
+	// the Go decoder agrees on the values:
+	e, err := brass.NewDecoder(strings.NewReader(got)).Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := brass.MakeList([]*brass.SExpr{
+		brass.MakeInt64(brass.MaxInteger),
+		brass.MakeFloat64(2),
+		brass.MakeFloat64(1.5),
+		brass.MakeInt64(-(1 << 53)),
+		brass.MakeInt64(math.MinInt64),
+	})
+	if !reflect.DeepEqual(e, want) {
+		t.Fatalf("want %s\ngot  %s", want, e)
+	}
+}
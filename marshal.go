@@ -0,0 +1,663 @@
+package brass
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+var ErrInvalidUnmarshal = errors.New("unmarshal target must be a non-nil pointer")
+
+// BrassMarshaler is implemented by types which control their own encoding.
+type BrassMarshaler interface {
+	MarshalBrass() (*SExpr, error)
+}
+
+// BrassUnmarshaler is implemented by types which control their own decoding.
+type BrassUnmarshaler interface {
+	UnmarshalBrass(e *SExpr) error
+}
+
+var (
+	marshalerType   = reflect.TypeOf((*BrassMarshaler)(nil)).Elem()
+	unmarshalerType = reflect.TypeOf((*BrassUnmarshaler)(nil)).Elem()
+	sexprType       = reflect.TypeOf((*SExpr)(nil))
+	symbolType      = reflect.TypeOf(Symbol(""))
+	octetsKeyType   = reflect.TypeOf(OctetsKey(""))
+)
+
+// Marshal converts a Go value to an expression:
+//
+//	BrassMarshaler          -> the result of MarshalBrass
+//	*SExpr                  -> as is
+//	registered tag types    -> tagged value converted by the codec registered in DefaultTagRegistry
+//	registered union types  -> variant encoded as configured by the Union registered with RegisterUnion
+//	nil pointer, interface,
+//	slice or map            -> nil
+//	bool                    -> bool
+//	integer types           -> integer
+//	float types             -> float
+//	Symbol                  -> symbol
+//	OctetsKey               -> octets
+//	other string types      -> string
+//	[]byte and [N]byte      -> octets
+//	other slices and arrays -> list
+//	maps                    -> map with keys converted to primitives
+//	structs                 -> map keyed by field name
+//
+// Struct fields are named by the `brass:"name"` field tag or else by the Go field name. A name of "-" skips the
+// field and the option "omitempty" skips the field when it has an empty value, e.g. `brass:"hp,omitempty"`.
+// Unexported fields are skipped and the fields of embedded structs without a name are included as if they were
+// fields of the outer struct; fields of the same name are resolved by the Go visibility rules as by encoding/json.
+// A struct with a blank field `_ struct{}` tagged with the "tuple" option, i.e.
+// `brass:",tuple"`, is instead encoded as a list of its field values in order, omitting trailing
+// omitempty fields which have empty values, and is decoded by position.
+func Marshal(v any) (*SExpr, error) {
+	return marshal(make(Path, 0, 8), reflect.ValueOf(v))
+}
+
+func marshal(path Path, v reflect.Value) (e *SExpr, err error) {
+	if !v.IsValid() {
+		return MakeNil(), nil
+	}
+
+	t := v.Type()
+	if t == sexprType {
+		if v.IsNil() {
+			return MakeNil(), nil
+		}
+		return v.Interface().(*SExpr), nil
+	}
+
+	if t.Implements(marshalerType) {
+		if (v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface) && v.IsNil() {
+			return MakeNil(), nil
+		}
+		return marshalWith(path, v.Interface().(BrassMarshaler))
+	}
+	if v.Kind() != reflect.Pointer && v.CanAddr() && reflect.PointerTo(t).Implements(marshalerType) {
+		return marshalWith(path, v.Addr().Interface().(BrassMarshaler))
+	}
+
+	if tag, codec, ok := DefaultTagRegistry.lookupType(t); ok {
+		var inner *SExpr
+		inner, err = codec.Encode(v.Interface())
+		if err != nil {
+			err = atPath(path, fmt.Errorf("tag %s: %w", tag, err))
+			return
+		}
+		return &SExpr{kind: KindTagged, octets: tag, tagged: inner, value: v.Interface()}, nil
+	}
+
+	switch v.Kind() {
+	case reflect.Interface, reflect.Pointer:
+		if v.IsNil() {
+			return MakeNil(), nil
+		}
+		if u, ok := lookupUnion(t); ok {
+			return u.marshalVariant(path, v.Elem())
+		}
+		return marshal(path, v.Elem())
+	case reflect.Bool:
+		return MakeBool(v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return MakeInt64(v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return fromUint64(path, v.Uint())
+	case reflect.Float32, reflect.Float64:
+		return MakeFloat64(v.Float()), nil
+	case reflect.String:
+		switch t {
+		case symbolType:
+			return fromAny(path, Symbol(v.String()))
+		case octetsKeyType:
+			return &SExpr{kind: KindOctets, octets: v.String()}, nil
+		default:
+			return MakeString(v.String()), nil
+		}
+	case reflect.Slice:
+		if v.IsNil() {
+			return MakeNil(), nil
+		}
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &SExpr{kind: KindOctets, octets: string(v.Bytes())}, nil
+		}
+		return marshalList(path, v)
+	case reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			b := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(b), v)
+			return &SExpr{kind: KindOctets, octets: string(b)}, nil
+		}
+		return marshalList(path, v)
+	case reflect.Map:
+		if v.IsNil() {
+			return MakeNil(), nil
+		}
+		return marshalMap(path, v)
+	case reflect.Struct:
+		return marshalStruct(path, v)
+	default:
+		err = atPath(path, fmt.Errorf("%w: %v", ErrUnrepresentable, t))
+		return
+	}
+}
+
+func marshalWith(path Path, m BrassMarshaler) (e *SExpr, err error) {
+	e, err = m.MarshalBrass()
+	if err != nil {
+		err = atPath(path, err)
+		return
+	}
+	if e == nil {
+		e = MakeNil()
+	}
+	return
+}
+
+func marshalList(path Path, v reflect.Value) (e *SExpr, err error) {
+	list := make([]*SExpr, v.Len())
+	for i := range list {
+		list[i], err = marshal(append(path, PathElem{Index: i}), v.Index(i))
+		if err != nil {
+			return
+		}
+	}
+	return MakeList(list), nil
+}
+
+func marshalMap(path Path, v reflect.Value) (e *SExpr, err error) {
+	dict := make(map[SExprPrimitive]*SExpr, v.Len())
+	iter := v.MapRange()
+	for iter.Next() {
+		var k *SExpr
+		k, err = marshal(path, iter.Key())
+		if err != nil {
+			return
+		}
+
+		var p SExprPrimitive
+		p, err = k.Primitive()
+		if err != nil {
+			err = atPath(path, err)
+			return
+		}
+
+		dict[p], err = marshal(append(path, PathElem{IsKey: true, Key: p}), iter.Value())
+		if err != nil {
+			return
+		}
+	}
+	return MakeMap(dict), nil
+}
+
+func marshalStruct(path Path, v reflect.Value) (e *SExpr, err error) {
+	info := cachedStructInfo(v.Type())
+	if info.tuple {
+		var list []*SExpr
+		list, err = marshalTuple(path, v, 0)
+		if err != nil {
+			return
+		}
+		return MakeList(list), nil
+	}
+
+	dict := make(map[SExprPrimitive]*SExpr, len(info.fields))
+	for i := range info.fields {
+		f := &info.fields[i]
+
+		fv := v.FieldByIndex(f.index)
+		if f.omitEmpty && isEmptyValue(fv) {
+			continue
+		}
+
+		dict[f.key], err = marshal(append(path, PathElem{IsKey: true, Key: f.key}), fv)
+		if err != nil {
+			return
+		}
+	}
+	return MakeMap(dict), nil
+}
+
+// isEmptyValue returns true for false, 0, nil pointers and interfaces, and empty arrays, slices, maps and strings.
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Pointer:
+		return v.IsNil()
+	default:
+		return false
+	}
+}
+
+// structField describes how a struct field is encoded.
+type structField struct {
+	name      string
+	key       SExprPrimitive
+	index     []int
+	omitEmpty bool
+	// tagged is set if the name was given by the field tag:
+	tagged bool
+}
+
+// structInfo describes how a struct type is encoded.
+type structInfo struct {
+	fields []structField
+	byName map[string]int
+	// tuple is set by the "tuple" option on a blank field and encodes the struct as a list in field order:
+	tuple bool
+}
+
+var structInfoCache sync.Map
+
+// cachedStructInfo returns the encoding of a struct type.
+func cachedStructInfo(t reflect.Type) *structInfo {
+	if info, ok := structInfoCache.Load(t); ok {
+		return info.(*structInfo)
+	}
+
+	info := &structInfo{byName: make(map[string]int)}
+	collectStructFields(info, t, nil)
+	resolveStructFields(info)
+
+	actual, _ := structInfoCache.LoadOrStore(t, info)
+	return actual.(*structInfo)
+}
+
+func collectStructFields(info *structInfo, t reflect.Type, index []int) {
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+
+		tag := sf.Tag.Get("brass")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+
+		// copy the index so that sibling fields do not share storage:
+		fieldIndex := make([]int, len(index)+1)
+		copy(fieldIndex, index)
+		fieldIndex[len(index)] = i
+
+		if sf.Name == "_" {
+			if len(index) == 0 && hasOption(opts, "tuple") {
+				info.tuple = true
+			}
+			continue
+		}
+		if sf.Anonymous && name == "" && sf.Type.Kind() == reflect.Struct {
+			collectStructFields(info, sf.Type, fieldIndex)
+			continue
+		}
+		if !sf.IsExported() {
+			continue
+		}
+
+		tagged := name != ""
+		if !tagged {
+			name = sf.Name
+		}
+
+		info.fields = append(info.fields, structField{
+			name:      name,
+			key:       PrimitiveString(name),
+			index:     fieldIndex,
+			omitEmpty: hasOption(opts, "omitempty"),
+			tagged:    tagged,
+		})
+	}
+}
+
+// resolveStructFields removes the fields collected from embedded structs which are hidden by other fields of the
+// same name as encoding/json does: the least nested field wins, or else the only tagged field at that depth, and
+// otherwise all fields of that name are dropped as ambiguous.
+func resolveStructFields(info *structInfo) {
+	candidates := make(map[string][]int)
+	for i, f := range info.fields {
+		candidates[f.name] = append(candidates[f.name], i)
+	}
+
+	keep := make([]bool, len(info.fields))
+	for _, c := range candidates {
+		if i, ok := dominantField(info.fields, c); ok {
+			keep[i] = true
+		}
+	}
+
+	all := info.fields
+	info.fields = make([]structField, 0, len(candidates))
+	for i, f := range all {
+		if keep[i] {
+			info.byName[f.name] = len(info.fields)
+			info.fields = append(info.fields, f)
+		}
+	}
+}
+
+// dominantField returns the field among the candidates of the same name which hides the others, if any.
+func dominantField(fields []structField, candidates []int) (dominant int, ok bool) {
+	depth := len(fields[candidates[0]].index)
+	for _, i := range candidates[1:] {
+		if d := len(fields[i].index); d < depth {
+			depth = d
+		}
+	}
+
+	var top, tagged []int
+	for _, i := range candidates {
+		if len(fields[i].index) != depth {
+			continue
+		}
+		top = append(top, i)
+		if fields[i].tagged {
+			tagged = append(tagged, i)
+		}
+	}
+
+	if len(top) == 1 {
+		return top[0], true
+	}
+	if len(tagged) == 1 {
+		return tagged[0], true
+	}
+	return
+}
+
+// hasOption returns true if the comma-separated options of a field tag include opt.
+func hasOption(opts string, opt string) bool {
+	for opts != "" {
+		var o string
+		o, opts, _ = strings.Cut(opts, ",")
+		if o == opt {
+			return true
+		}
+	}
+	return false
+}
+
+// Unmarshal converts an expression to the Go value pointed to by v, reversing the conversions made by Marshal.
+// Expressions are converted to an empty interface as by ToAny and to an interface with a registered Union as the
+// variant's concrete type. Integers are also accepted for float types.
+// Struct fields absent from a map are left unchanged and map entries without a corresponding field are ignored.
+// Nil leaves values that cannot be nil unchanged. Raw expressions are decoded as needed. Kind mismatches fail
+// with ErrWrongKind and integers that overflow their Go type fail with ErrUnrepresentable, along with the path
+// at which they occurred.
+func Unmarshal(e *SExpr, v any) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return ErrInvalidUnmarshal
+	}
+	return unmarshal(make(Path, 0, 8), e, rv.Elem())
+}
+
+// mismatch returns ErrWrongKind for an expression which cannot be converted to a Go type.
+func mismatch(path Path, e *SExpr, t reflect.Type) error {
+	return atPath(path, fmt.Errorf("%w: cannot unmarshal %v into %v", ErrWrongKind, e.kind, t))
+}
+
+func unmarshal(path Path, e *SExpr, v reflect.Value) (err error) {
+	t := v.Type()
+	if t == sexprType {
+		v.Set(reflect.ValueOf(e))
+		return
+	}
+
+	if e.kind == KindRaw {
+		e, err = RawSExpr(e.octets).Decode()
+		if err != nil {
+			err = atPath(path, err)
+			return
+		}
+	}
+
+	if v.Kind() == reflect.Pointer && t.Implements(unmarshalerType) {
+		if e.kind == KindNil {
+			v.Set(reflect.Zero(t))
+			return
+		}
+		if v.IsNil() {
+			v.Set(reflect.New(t.Elem()))
+		}
+		return unmarshalWith(path, e, v.Interface().(BrassUnmarshaler))
+	}
+	if v.Kind() != reflect.Pointer && v.CanAddr() && reflect.PointerTo(t).Implements(unmarshalerType) {
+		return unmarshalWith(path, e, v.Addr().Interface().(BrassUnmarshaler))
+	}
+
+	if tag, codec, ok := DefaultTagRegistry.lookupType(t); ok {
+		if e.kind == KindNil {
+			return
+		}
+		if e.kind != KindTagged || e.octets != tag {
+			err = atPath(path, fmt.Errorf("%w: cannot unmarshal %v into %v as @%s", ErrWrongKind, e.kind, t, tag))
+			return
+		}
+
+		x := e.value
+		if x == nil || reflect.TypeOf(x) != t {
+			x, err = codec.Decode(e.tagged)
+			if err != nil {
+				err = atPath(path, fmt.Errorf("tag %s: %w", tag, err))
+				return
+			}
+		}
+		v.Set(reflect.ValueOf(x))
+		return
+	}
+
+	switch v.Kind() {
+	case reflect.Interface:
+		if e.kind == KindNil {
+			v.Set(reflect.Zero(t))
+			return
+		}
+		if u, ok := lookupUnion(t); ok {
+			return u.unmarshalVariant(path, e, v)
+		}
+		if t.NumMethod() != 0 {
+			err = atPath(path, fmt.Errorf("%w: %v", ErrUnrepresentable, t))
+			return
+		}
+		v.Set(reflect.ValueOf(e.ToAny()))
+		return
+	case reflect.Pointer:
+		if e.kind == KindNil {
+			v.Set(reflect.Zero(t))
+			return
+		}
+		if v.IsNil() {
+			v.Set(reflect.New(t.Elem()))
+		}
+		return unmarshal(path, e, v.Elem())
+	}
+
+	if e.kind == KindNil {
+		switch v.Kind() {
+		case reflect.Slice, reflect.Map:
+			v.Set(reflect.Zero(t))
+		}
+		return
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		if e.kind != KindBool {
+			return mismatch(path, e, t)
+		}
+		v.SetBool(e.integer != 0)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if e.kind != KindInteger {
+			return mismatch(path, e, t)
+		}
+		if v.OverflowInt(e.integer) {
+			return atPath(path, fmt.Errorf("%w: integer %d overflows %v", ErrUnrepresentable, e.integer, t))
+		}
+		v.SetInt(e.integer)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if e.kind != KindInteger {
+			return mismatch(path, e, t)
+		}
+		if e.integer < 0 || v.OverflowUint(uint64(e.integer)) {
+			return atPath(path, fmt.Errorf("%w: integer %d overflows %v", ErrUnrepresentable, e.integer, t))
+		}
+		v.SetUint(uint64(e.integer))
+	case reflect.Float32, reflect.Float64:
+		switch e.kind {
+		case KindFloat:
+			v.SetFloat(math.Float64frombits(uint64(e.integer)))
+		case KindInteger:
+			v.SetFloat(float64(e.integer))
+		default:
+			return mismatch(path, e, t)
+		}
+	case reflect.String:
+		want := KindString
+		switch t {
+		case symbolType:
+			want = KindSymbol
+		case octetsKeyType:
+			want = KindOctets
+		}
+		if e.kind != want {
+			return mismatch(path, e, t)
+		}
+		v.SetString(e.octets)
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			if e.kind != KindOctets {
+				return mismatch(path, e, t)
+			}
+			b := reflect.MakeSlice(t, len(e.octets), len(e.octets))
+			reflect.Copy(b, reflect.ValueOf(e.octets))
+			v.Set(b)
+			return
+		}
+		if e.kind != KindList {
+			return mismatch(path, e, t)
+		}
+		s := reflect.MakeSlice(t, len(e.list), len(e.list))
+		for i, c := range e.list {
+			err = unmarshal(append(path, PathElem{Index: i}), c, s.Index(i))
+			if err != nil {
+				return
+			}
+		}
+		v.Set(s)
+	case reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			if e.kind != KindOctets {
+				return mismatch(path, e, t)
+			}
+			if len(e.octets) != v.Len() {
+				return atPath(path, fmt.Errorf("%w: %d octets into %v", ErrUnrepresentable, len(e.octets), t))
+			}
+			reflect.Copy(v, reflect.ValueOf(e.octets))
+			return
+		}
+		if e.kind != KindList {
+			return mismatch(path, e, t)
+		}
+		if len(e.list) > v.Len() {
+			return atPath(path, fmt.Errorf("%w: %d elements into %v", ErrUnrepresentable, len(e.list), t))
+		}
+		for i := 0; i < v.Len(); i++ {
+			if i >= len(e.list) {
+				v.Index(i).Set(reflect.Zero(t.Elem()))
+				continue
+			}
+			err = unmarshal(append(path, PathElem{Index: i}), e.list[i], v.Index(i))
+			if err != nil {
+				return
+			}
+		}
+	case reflect.Map:
+		if e.kind != KindMap {
+			return mismatch(path, e, t)
+		}
+		if v.IsNil() {
+			v.Set(reflect.MakeMapWithSize(t, len(e.dict)))
+		}
+		for _, en := range mapEntries(e) {
+			elemPath := append(path, PathElem{IsKey: true, Key: en.Key})
+
+			k := reflect.New(t.Key()).Elem()
+			key := SExpr{kind: en.Key.kind, integer: en.Key.integer, octets: en.Key.octets}
+			err = unmarshal(elemPath, &key, k)
+			if err != nil {
+				return
+			}
+
+			x := reflect.New(t.Elem()).Elem()
+			err = unmarshal(elemPath, en.Value, x)
+			if err != nil {
+				return
+			}
+			v.SetMapIndex(k, x)
+		}
+	case reflect.Struct:
+		info := cachedStructInfo(t)
+		if info.tuple {
+			if e.kind != KindList {
+				return mismatch(path, e, t)
+			}
+			return unmarshalTuple(path, e.list, v, 0)
+		}
+		if e.kind != KindMap {
+			return mismatch(path, e, t)
+		}
+		for _, en := range mapEntries(e) {
+			if en.Key.kind != KindString {
+				continue
+			}
+			i, ok := info.byName[en.Key.octets]
+			if !ok {
+				continue
+			}
+
+			err = unmarshal(append(path, PathElem{IsKey: true, Key: en.Key}), en.Value, v.FieldByIndex(info.fields[i].index))
+			if err != nil {
+				return
+			}
+		}
+	default:
+		return atPath(path, fmt.Errorf("%w: %v", ErrUnrepresentable, t))
+	}
+	return
+}
+
+func unmarshalWith(path Path, e *SExpr, u BrassUnmarshaler) (err error) {
+	err = u.UnmarshalBrass(e)
+	if err != nil {
+		err = atPath(path, err)
+	}
+	return
+}
+
+// MarshalText encodes the expression so that it can be embedded as text in other encodings.
+func (e *SExpr) MarshalText() ([]byte, error) {
+	return e.AppendBrass(make([]byte, 0, e.EncodedLen())), nil
+}
+
+// UnmarshalText decodes a single expression of any kind, replacing e.
+func (e *SExpr) UnmarshalText(text []byte) (err error) {
+	var x *SExpr
+	x, err = RawSExpr(text).Decode()
+	if err != nil {
+		return
+	}
+
+	*e = *x
+	return
+}
@@ -0,0 +1,295 @@
+package brass
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// position is encoded as a compact list instead of a map.
+type position struct {
+	X, Y int
+}
+
+func (p position) MarshalBrass() (*SExpr, error) {
+	return MakeList([]*SExpr{MakeInt64(int64(p.X)), MakeInt64(int64(p.Y))}), nil
+}
+
+func (p *position) UnmarshalBrass(e *SExpr) error {
+	v, err := DecodeList(e, (*SExpr).Int64)
+	if err != nil {
+		return err
+	}
+	if len(v) != 2 {
+		return fmt.Errorf("expected 2 coordinates, got %d", len(v))
+	}
+	p.X, p.Y = int(v[0]), int(v[1])
+	return nil
+}
+
+type inventory struct {
+	Base
+	Name    string         `brass:"name"`
+	Pos     position       `brass:"pos"`
+	Items   []uint8        `brass:"items"`
+	Counts  map[string]int `brass:"counts,omitempty"`
+	Flags   [2]bool        `brass:"flags"`
+	Kind    Symbol         `brass:"kind"`
+	Ratio   float32        `brass:"ratio,omitempty"`
+	Next    *inventory     `brass:"next"`
+	Skip    int            `brass:"-"`
+	Extra   any            `brass:"extra"`
+	private int
+}
+
+type Base struct {
+	ID int64 `brass:"id"`
+}
+
+func TestMarshal(t *testing.T) {
+	v := inventory{
+		Base:  Base{ID: 7},
+		Name:  "link",
+		Pos:   position{X: 1, Y: -2},
+		Items: []uint8{1, 2},
+		Flags: [2]bool{true, false},
+		Kind:  "hero",
+		Skip:  3,
+		Extra: []any{int64(1), "a"},
+	}
+
+	e, err := Marshal(&v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{("extra" ($1 "a")) ("flags" (true false)) ("id" $7) ("items" #2$0102) ("kind" hero) ("name" "link") ("next" nil) ("pos" ($1 -$2))}`
+	if !reflect.DeepEqual(e, mustDecodeText(t, want)) {
+		t.Errorf("Marshal() = %s, want %s", e, want)
+	}
+
+	var got inventory
+	got.Skip = 3
+	if err = Unmarshal(e, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, v) {
+		t.Errorf("Unmarshal() = %+v, want %+v", got, v)
+	}
+}
+
+func mustDecodeText(t *testing.T, s string) *SExpr {
+	t.Helper()
+	e := &SExpr{}
+	if err := e.UnmarshalText([]byte(s)); err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func TestUnmarshal_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		v       any
+		wantErr error
+		wantMsg string
+	}{
+		{
+			name:    "wrong kind",
+			s:       `{("name" $1)}`,
+			v:       &inventory{},
+			wantErr: ErrWrongKind,
+			wantMsg: `["name"]: wrong kind: cannot unmarshal KindInteger into string`,
+		},
+		{
+			name:    "octets into list",
+			s:       `{("items" ($1 $2))}`,
+			v:       &inventory{},
+			wantErr: ErrWrongKind,
+		},
+		{
+			name:    "integer overflow",
+			s:       `($1 $10000)`,
+			v:       &[]uint16{},
+			wantErr: ErrUnrepresentable,
+			wantMsg: `[1]: value cannot be represented: integer 65536 overflows uint16`,
+		},
+		{
+			name:    "unmarshaler error",
+			s:       `{("pos" ($1))}`,
+			v:       &inventory{},
+			wantMsg: `["pos"]: expected 2 coordinates, got 1`,
+		},
+		{
+			name:    "array length",
+			s:       `(true true true)`,
+			v:       &[2]bool{},
+			wantErr: ErrUnrepresentable,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Unmarshal(mustDecodeText(t, tt.s), tt.v)
+			if err == nil {
+				t.Fatal("Unmarshal() expected error")
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("Unmarshal() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantMsg != "" && err.Error() != tt.wantMsg {
+				t.Errorf("Unmarshal() error = %q, want %q", err, tt.wantMsg)
+			}
+		})
+	}
+
+	if err := Unmarshal(MakeNil(), inventory{}); !errors.Is(err, ErrInvalidUnmarshal) {
+		t.Errorf("Unmarshal() error = %v, want %v", err, ErrInvalidUnmarshal)
+	}
+}
+
+func TestMarshal_Tagged(t *testing.T) {
+	r := DefaultTagRegistry
+	DefaultTagRegistry = testTagRegistry(t)
+	defer func() { DefaultTagRegistry = r }()
+
+	type event struct {
+		At time.Time `brass:"at"`
+	}
+	v := event{At: time.Unix(16, 0).UTC()}
+
+	e, err := Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := e.String(), `{("at" @time($10))}`; got != want {
+		t.Errorf("Marshal() = %s, want %s", got, want)
+	}
+
+	var got event
+	if err = Unmarshal(mustDecodeText(t, `{("at" @time($10))}`), &got); err != nil {
+		t.Fatal(err)
+	}
+	if !got.At.Equal(v.At) {
+		t.Errorf("Unmarshal() = %v, want %v", got, v)
+	}
+
+	if _, err = Marshal(make(chan int)); !errors.Is(err, ErrUnrepresentable) {
+		t.Errorf("Marshal() error = %v, want %v", err, ErrUnrepresentable)
+	}
+}
+
+func TestMarshal_Tuple(t *testing.T) {
+	tests := []struct {
+		name string
+		v    velocity
+		want string
+	}{
+		{name: "trailing omitted", v: velocity{DX: 1, DY: -2}, want: `($1 -$2)`},
+		{name: "all fields", v: velocity{DY: 1, Scale: 0.5}, want: `($0 $1 %3fe0000000000000)`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := Marshal(tt.v)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := e.String(); got != tt.want {
+				t.Errorf("Marshal() = %s, want %s", got, tt.want)
+			}
+
+			var got velocity
+			if err = Unmarshal(e, &got); err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.v {
+				t.Errorf("Unmarshal() = %+v, want %+v", got, tt.v)
+			}
+		})
+	}
+
+	// the map encoding is not accepted for tuples:
+	var got velocity
+	if err := Unmarshal(mustDecodeText(t, `{("DX" $1)}`), &got); !errors.Is(err, ErrWrongKind) {
+		t.Errorf("Unmarshal() error = %v, want %v", err, ErrWrongKind)
+	}
+	if err := Unmarshal(mustDecodeText(t, `($1 $2 $3 $4)`), &got); !errors.Is(err, ErrUnrepresentable) {
+		t.Errorf("Unmarshal() error = %v, want %v", err, ErrUnrepresentable)
+	}
+}
+
+type embeddedA struct {
+	X, Y int
+	W    int
+}
+
+type embeddedB struct {
+	Y int
+	W int `brass:"W"`
+}
+
+type embedding struct {
+	embeddedA
+	embeddedB
+	X int
+}
+
+func TestMarshal_Embedded(t *testing.T) {
+	// X is shadowed by the outer field, W is resolved by its tag and Y is ambiguous:
+	v := embedding{embeddedA: embeddedA{X: 1, Y: 2, W: 3}, embeddedB: embeddedB{Y: 4, W: 5}, X: 6}
+	e, err := Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := e.String(), `{("W" $5) ("X" $6)}`; got != want {
+		t.Errorf("Marshal() = %s, want %s", got, want)
+	}
+
+	s := `{("W" $7) ("X" $8) ("Y" $9)}`
+	want := embedding{embeddedB: embeddedB{W: 7}, X: 8}
+
+	var got embedding
+	if err = Unmarshal(mustDecodeText(t, s), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("Unmarshal() = %+v, want %+v", got, want)
+	}
+
+	var into []embedding
+	if err = NewDecoder(strings.NewReader("(" + s + ")")).DecodeInto(&into); err != nil {
+		t.Fatal(err)
+	}
+	if len(into) != 1 || into[0] != want {
+		t.Errorf("DecodeInto() = %+v, want %+v", into, want)
+	}
+}
+
+func TestSExpr_TextMarshaler(t *testing.T) {
+	type message struct {
+		Payload *SExpr `json:"payload"`
+	}
+
+	b, err := json.Marshal(message{Payload: MakeList([]*SExpr{MakeString("a"), MakeInt64(1)})})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(b), `{"payload":"(\"a\" $1)"}`; got != want {
+		t.Errorf("json.Marshal() = %s, want %s", got, want)
+	}
+
+	var m message
+	if err = json.Unmarshal(b, &m); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := m.Payload.String(), `("a" $1)`; got != want {
+		t.Errorf("json.Unmarshal() = %s, want %s", got, want)
+	}
+
+	if err = m.Payload.UnmarshalText([]byte(`$1 $2`)); !errors.Is(err, ErrUnexpectedCharacter) {
+		t.Errorf("UnmarshalText() error = %v, want %v", err, ErrUnexpectedCharacter)
+	}
+}
@@ -0,0 +1,94 @@
+package brass
+
+// MapEntry is a single key-value pair of a map.
+type MapEntry struct {
+	Key   SExprPrimitive
+	Value *SExpr
+}
+
+// OrderedMap is a map which preserves the order of its entries. Entries are kept in a slice in order and indexed
+// by key for lookups.
+type OrderedMap struct {
+	entries []MapEntry
+	index   map[SExprPrimitive]int
+}
+
+// NewOrderedMap creates an empty OrderedMap with room for n entries.
+func NewOrderedMap(n int) *OrderedMap {
+	return &OrderedMap{
+		entries: make([]MapEntry, 0, n),
+		index:   make(map[SExprPrimitive]int, n),
+	}
+}
+
+// Len returns the number of entries.
+func (m *OrderedMap) Len() int { return len(m.entries) }
+
+// Entries returns the entries in order. The returned slice must not be modified.
+func (m *OrderedMap) Entries() []MapEntry { return m.entries }
+
+// Get returns the value for key k and whether it is present.
+func (m *OrderedMap) Get(k SExprPrimitive) (v *SExpr, ok bool) {
+	var i int
+	i, ok = m.index[k]
+	if !ok {
+		return
+	}
+
+	v = m.entries[i].Value
+	return
+}
+
+// Set sets the value for key k. A new key is appended after all existing entries; an existing key keeps its
+// position.
+func (m *OrderedMap) Set(k SExprPrimitive, v *SExpr) {
+	if i, ok := m.index[k]; ok {
+		m.entries[i].Value = v
+		return
+	}
+
+	m.index[k] = len(m.entries)
+	m.entries = append(m.entries, MapEntry{Key: k, Value: v})
+}
+
+// Delete removes the entry for key k if present, preserving the order of the remaining entries.
+func (m *OrderedMap) Delete(k SExprPrimitive) {
+	i, ok := m.index[k]
+	if !ok {
+		return
+	}
+
+	delete(m.index, k)
+	copy(m.entries[i:], m.entries[i+1:])
+	m.entries[len(m.entries)-1] = MapEntry{}
+	m.entries = m.entries[:len(m.entries)-1]
+	for ; i < len(m.entries); i++ {
+		m.index[m.entries[i].Key] = i
+	}
+}
+
+// clear removes all entries while retaining storage.
+func (m *OrderedMap) clear() {
+	for i := range m.entries {
+		m.entries[i] = MapEntry{}
+	}
+	m.entries = m.entries[:0]
+	for k := range m.index {
+		delete(m.index, k)
+	}
+}
+
+// toMap copies the entries into a Go map.
+func (m *OrderedMap) toMap() map[SExprPrimitive]*SExpr {
+	dict := make(map[SExprPrimitive]*SExpr, len(m.entries))
+	for _, en := range m.entries {
+		dict[en.Key] = en.Value
+	}
+	return dict
+}
+
+// UseOrderedMaps instructs the decoder to decode maps as OrderedMaps which preserve the order of entries as
+// they appear in the input.
+func (d *Decoder) UseOrderedMaps() {
+	d.orderedMaps = true
+}
@@ -0,0 +1,116 @@
+package brass
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestDecoder_UseOrderedMaps(t *testing.T) {
+	tests := []string{
+		`({("z" $1) ("a" $2) ($3 {("y" nil) ("b" ())}) (#1$00 true)})`,
+		`({})`,
+		`({("b" $1) ("a" $2)} {("d" $1) ("c" $2)})`,
+	}
+	for _, tt := range tests {
+		t.Run(tt, func(t *testing.T) {
+			d := NewDecoder(bytes.NewBufferString(tt))
+			d.UseOrderedMaps()
+			e, err := d.Decode()
+			if err != nil {
+				t.Fatalf("Decode() error = %v", err)
+			}
+			if got := e.String(); got != tt {
+				t.Errorf("String() = %v, want %v", got, tt)
+			}
+			if got := e.EncodedLen(); got != len(tt) {
+				t.Errorf("EncodedLen() = %v, want %v", got, len(tt))
+			}
+
+			// release and decode again to exercise reuse of ordered map storage:
+			d.Release(e)
+			d.Reset(bytes.NewBufferString(tt))
+			e, err = d.Decode()
+			if err != nil {
+				t.Fatalf("Decode() error = %v", err)
+			}
+			if got := e.String(); got != tt {
+				t.Errorf("String() after Release() = %v, want %v", got, tt)
+			}
+		})
+	}
+}
+
+func TestOrderedMap(t *testing.T) {
+	m := NewOrderedMap(0)
+	m.Set(PrimitiveString("c"), MakeInt64(1))
+	m.Set(PrimitiveString("a"), MakeInt64(2))
+	m.Set(PrimitiveInt64(5), MakeInt64(3))
+	m.Set(PrimitiveString("a"), MakeInt64(4))
+
+	e := MakeOrderedMap(m)
+	if got, want := e.String(), `{("c" $1) ("a" $4) ($5 $3)}`; got != want {
+		t.Errorf("String() = %v, want %v", got, want)
+	}
+
+	m.Delete(PrimitiveString("c"))
+	m.Delete(PrimitiveString("x"))
+	if got, want := e.String(), `{("a" $4) ($5 $3)}`; got != want {
+		t.Errorf("String() after Delete() = %v, want %v", got, want)
+	}
+	if v, ok := m.Get(PrimitiveInt64(5)); !ok || v.AsInt64() != 3 {
+		t.Errorf("Get() = %v, %v", v, ok)
+	}
+	if _, ok := m.Get(PrimitiveString("c")); ok {
+		t.Errorf("Get() of deleted key succeeded")
+	}
+
+	want := map[SExprPrimitive]*SExpr{
+		PrimitiveString("a"): MakeInt64(4),
+		PrimitiveInt64(5):    MakeInt64(3),
+	}
+	if got := e.AsMap(); !reflect.DeepEqual(got, want) {
+		t.Errorf("AsMap() = %v, want %v", got, want)
+	}
+
+	o := MakeMap(want).AsOrderedMap()
+	if got := o.Entries(); got[0].Key != PrimitiveInt64(5) || got[1].Key != PrimitiveString("a") {
+		t.Errorf("AsOrderedMap() = %v", got)
+	}
+}
+
+func TestSExpr_AsMapMutations(t *testing.T) {
+	// the Go map of an ordered map is a copy which leaves the order intact:
+	d := NewDecoder(bytes.NewBufferString(`({("b" $1) ("a" $2)})`))
+	d.UseOrderedMaps()
+	e, err := d.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := e.AsList()[0]
+	m.AsMap()[PrimitiveString("c")] = MakeInt64(3)
+	if !m.IsOrderedMap() {
+		t.Errorf("IsOrderedMap() after AsMap() = false")
+	}
+	if got, want := m.String(), `{("b" $1) ("a" $2)}`; got != want {
+		t.Errorf("String() after AsMap() = %v, want %v", got, want)
+	}
+
+	// changes to the Go map of an unordered map persist:
+	u := MakeMap(map[SExprPrimitive]*SExpr{PrimitiveString("a"): MakeInt64(2)})
+	u.AsMap()[PrimitiveString("c")] = MakeInt64(3)
+	want := map[SExprPrimitive]*SExpr{
+		PrimitiveString("a"): MakeInt64(2),
+		PrimitiveString("c"): MakeInt64(3),
+	}
+	if got := u.AsMap(); !reflect.DeepEqual(got, want) {
+		t.Errorf("AsMap() = %v, want %v", got, want)
+	}
+
+	// changes to the OrderedMap of an unordered map persist:
+	u.AsOrderedMap().Set(PrimitiveString("b"), MakeInt64(4))
+	u.AsOrderedMap().Delete(PrimitiveString("c"))
+	if got, want := u.String(), `{("a" $2) ("b" $4)}`; got != want {
+		t.Errorf("String() after AsOrderedMap() = %v, want %v", got, want)
+	}
+}
@@ -2,6 +2,9 @@ package brass
 
 import (
 	"fmt"
+	"math"
+	"math/bits"
+	"strconv"
 	"strings"
 )
 
@@ -22,6 +25,13 @@ type MutablePrimitive interface {
 	SetOctets(v string)
 }
 
+// mutablePrimitive is implemented by SExpr and SExprPrimitive to set any kind of primitive while decoding.
+type mutablePrimitive interface {
+	MutablePrimitive
+	SetFloat64(v float64)
+	SetSymbol(v string)
+}
+
 type SExprPrimitive struct {
 	kind    Kind
 	integer int64
@@ -54,6 +64,12 @@ func (e *SExprPrimitive) SetInt64(v int64) {
 	e.integer = v
 }
 
+func (e *SExprPrimitive) SetFloat64(v float64) {
+	e.reset()
+	e.kind = KindFloat
+	e.integer = floatBits(v)
+}
+
 func (e *SExprPrimitive) SetString(v string) {
 	e.reset()
 	e.kind = KindString
@@ -66,6 +82,14 @@ func (e *SExprPrimitive) SetOctets(v string) {
 	e.octets = v
 }
 
+// SetSymbol sets a symbol. It panics if v is not a valid symbol.
+func (e *SExprPrimitive) SetSymbol(v string) {
+	mustBeSymbol(v)
+	e.reset()
+	e.kind = KindSymbol
+	e.octets = v
+}
+
 func (e *SExprPrimitive) Kind() Kind { return e.kind }
 
 func (e *SExprPrimitive) IsNil() bool {
@@ -87,6 +111,14 @@ func (e *SExprPrimitive) AsInt64() int64 {
 	return e.integer
 }
 
+func (e *SExprPrimitive) AsFloat64() float64 {
+	kind := e.kind
+	if kind != KindFloat {
+		panic("must be KindFloat")
+	}
+	return math.Float64frombits(uint64(e.integer))
+}
+
 func (e *SExprPrimitive) AsString() string {
 	kind := e.kind
 	if kind != KindString {
@@ -103,56 +135,196 @@ func (e *SExprPrimitive) AsOctets() []byte {
 	return []byte(e.octets)
 }
 
+func (e *SExprPrimitive) AsSymbol() string {
+	kind := e.kind
+	if kind != KindSymbol {
+		panic("must be KindSymbol")
+	}
+	return e.octets
+}
+
 func (e *SExprPrimitive) AppendTo(sb *strings.Builder) {
-	switch e.kind {
+	writePrimitive(sb, e.kind, e.integer, e.octets)
+}
+
+// AppendBrass appends the encoding of the primitive to dst and returns the extended slice.
+func (e *SExprPrimitive) AppendBrass(dst []byte) []byte {
+	return appendPrimitive(dst, e.kind, e.integer, e.octets)
+}
+
+// EncodedLen returns the exact length in bytes of the encoding of the primitive.
+func (e *SExprPrimitive) EncodedLen() int {
+	return primitiveEncodedLen(e.kind, e.integer, e.octets)
+}
+
+const hexDigits = "0123456789abcdef"
+
+// escapes maps each byte to its escape sequence within a string atom or to "" if it is not escaped.
+var escapes = func() (t [256]string) {
+	for i := range t {
+		b := byte(i)
+		if b < 32 || b >= 128 {
+			t[i] = string([]byte{'\\', 'x', hexDigits[b>>4], hexDigits[b&15]})
+		}
+	}
+	t['\\'] = "\\\\"
+	t['"'] = "\\\""
+	t['\r'] = "\\r"
+	t['\n'] = "\\n"
+	t['\t'] = "\\t"
+	return
+}()
+
+// grow ensures dst has capacity for at least n more bytes.
+func grow(dst []byte, n int) []byte {
+	if cap(dst)-len(dst) >= n {
+		return dst
+	}
+	return append(dst[:cap(dst)], make([]byte, n-(cap(dst)-len(dst)))...)[:len(dst)]
+}
+
+// hexLen returns the number of hex digits needed to encode v.
+func hexLen(v uint64) int {
+	if v == 0 {
+		return 1
+	}
+	return (bits.Len64(v) + 3) / 4
+}
+
+func appendHex(dst []byte, v uint64) []byte {
+	return strconv.AppendUint(dst, v, 16)
+}
+
+func appendPrimitive(dst []byte, kind Kind, integer int64, octets string) []byte {
+	switch kind {
 	case KindNil:
-		sb.WriteString("nil")
-		return
+		return append(dst, "nil"...)
 	case KindBool:
-		if e.integer != 0 {
-			sb.WriteString("true")
-		} else {
-			sb.WriteString("false")
+		if integer != 0 {
+			return append(dst, "true"...)
 		}
-		return
+		return append(dst, "false"...)
 	case KindInteger:
-		if e.integer < 0 {
-			fmt.Fprintf(sb, "-$%x", -e.integer)
-		} else {
-			fmt.Fprintf(sb, "$%x", e.integer)
+		if integer < 0 {
+			dst = append(dst, '-', '$')
+			return appendHex(dst, uint64(-integer))
+		}
+		dst = append(dst, '$')
+		return appendHex(dst, uint64(integer))
+	case KindFloat:
+		dst = append(dst, '%')
+		for shift := 60; shift >= 0; shift -= 4 {
+			dst = append(dst, hexDigits[uint64(integer)>>shift&15])
 		}
-		return
+		return dst
 	case KindOctets:
+		dst = grow(dst, primitiveEncodedLen(kind, integer, octets))
+		dst = append(dst, '#')
+		dst = appendHex(dst, uint64(len(octets)))
+		dst = append(dst, '$')
+		for i := 0; i < len(octets); i++ {
+			b := octets[i]
+			dst = append(dst, hexDigits[b>>4], hexDigits[b&15])
+		}
+		return dst
+	case KindString:
+		dst = grow(dst, primitiveEncodedLen(kind, integer, octets))
+		dst = append(dst, '"')
+		for i := 0; i < len(octets); i++ {
+			b := octets[i]
+			if esc := escapes[b]; esc != "" {
+				dst = append(dst, esc...)
+			} else {
+				dst = append(dst, b)
+			}
+		}
+		return append(dst, '"')
+	case KindSymbol:
+		return append(dst, octets...)
+	default:
+		panic(fmt.Errorf("unimplemented kind"))
+	}
+}
+
+// writePrimitive writes the encoding of a primitive to sb. Octets and strings are encoded in chunks through a
+// scratch buffer on the stack so that nothing is allocated beyond the builder's own growth.
+func writePrimitive(sb *strings.Builder, kind Kind, integer int64, octets string) {
+	var scratch [512]byte
+	switch kind {
+	case KindOctets:
+		sb.Grow(primitiveEncodedLen(kind, integer, octets))
 		sb.WriteByte('#')
-		fmt.Fprintf(sb, "%x", len(e.octets))
+		sb.Write(appendHex(scratch[:0], uint64(len(octets))))
 		sb.WriteByte('$')
-		for _, b := range e.octets {
-			fmt.Fprintf(sb, "%02x", b)
+		for len(octets) > 0 {
+			n := len(scratch) / 2
+			if n > len(octets) {
+				n = len(octets)
+			}
+			dst := scratch[:0]
+			for i := 0; i < n; i++ {
+				b := octets[i]
+				dst = append(dst, hexDigits[b>>4], hexDigits[b&15])
+			}
+			sb.Write(dst)
+			octets = octets[n:]
 		}
-		return
 	case KindString:
 		sb.WriteByte('"')
-		for _, b := range []byte(e.octets) {
-			if b == '\\' {
-				sb.WriteString("\\\\")
-			} else if b == '"' {
-				sb.WriteString("\\\"")
-			} else if b == '\r' {
-				sb.WriteString("\\r")
-			} else if b == '\n' {
-				sb.WriteString("\\n")
-			} else if b == '\t' {
-				sb.WriteString("\\t")
-			} else if b < 32 {
-				fmt.Fprintf(sb, "\\x%02x", b)
-			} else if b >= 128 {
-				fmt.Fprintf(sb, "\\x%02x", b)
-			} else {
-				sb.WriteByte(b)
+		for len(octets) > 0 {
+			// escapes are at most 4 bytes:
+			n := len(scratch) / 4
+			if n > len(octets) {
+				n = len(octets)
 			}
+			dst := scratch[:0]
+			for i := 0; i < n; i++ {
+				b := octets[i]
+				if esc := escapes[b]; esc != "" {
+					dst = append(dst, esc...)
+				} else {
+					dst = append(dst, b)
+				}
+			}
+			sb.Write(dst)
+			octets = octets[n:]
 		}
 		sb.WriteByte('"')
-		return
+	case KindSymbol:
+		sb.WriteString(octets)
+	default:
+		sb.Write(appendPrimitive(scratch[:0], kind, integer, octets))
+	}
+}
+
+func primitiveEncodedLen(kind Kind, integer int64, octets string) int {
+	switch kind {
+	case KindNil:
+		return 3
+	case KindBool:
+		if integer != 0 {
+			return 4
+		}
+		return 5
+	case KindInteger:
+		if integer < 0 {
+			return 2 + hexLen(uint64(-integer))
+		}
+		return 1 + hexLen(uint64(integer))
+	case KindFloat:
+		return 17
+	case KindOctets:
+		return 2 + hexLen(uint64(len(octets))) + 2*len(octets)
+	case KindString:
+		n := 2 + len(octets)
+		for i := 0; i < len(octets); i++ {
+			if esc := escapes[octets[i]]; esc != "" {
+				n += len(esc) - 1
+			}
+		}
+		return n
+	case KindSymbol:
+		return len(octets)
 	default:
 		panic(fmt.Errorf("unimplemented kind"))
 	}
@@ -166,8 +338,17 @@ func PrimitiveBool(v bool) SExprPrimitive {
 		return SExprPrimitive{kind: KindBool, integer: 0}
 	}
 }
-func PrimitiveInt64(v int64) SExprPrimitive   { return SExprPrimitive{kind: KindBool, integer: v} }
+func PrimitiveInt64(v int64) SExprPrimitive   { return SExprPrimitive{kind: KindInteger, integer: v} }
 func PrimitiveString(v string) SExprPrimitive { return SExprPrimitive{kind: KindString, octets: v} }
 func PrimitiveOctets(v []byte) SExprPrimitive {
 	return SExprPrimitive{kind: KindOctets, octets: string(v)}
 }
+func PrimitiveFloat64(v float64) SExprPrimitive {
+	return SExprPrimitive{kind: KindFloat, integer: floatBits(v)}
+}
+
+// PrimitiveSymbol makes a symbol primitive. It panics if v is not a valid symbol.
+func PrimitiveSymbol(v string) SExprPrimitive {
+	mustBeSymbol(v)
+	return SExprPrimitive{kind: KindSymbol, octets: v}
+}
@@ -0,0 +1,542 @@
+package brass
+
+import (
+	"bytes"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// RawSExpr is a raw encoded s-expression. It can be used to defer decoding of a sub-expression until it is
+// needed or to re-encode a previously decoded sub-expression verbatim.
+type RawSExpr []byte
+
+// Decode fully decodes the raw s-expression.
+func (r RawSExpr) Decode() (e *SExpr, err error) {
+	s := bytes.NewReader(r)
+	d := NewDecoder(s)
+
+	e = &SExpr{}
+	err = d.decodeNode(e, e)
+	if err != nil {
+		return
+	}
+	if s.Len() != 0 {
+		err = ErrUnexpectedCharacter
+		return
+	}
+
+	if len(d.labels) > 0 {
+		err = checkExpansion(e, d.labels, 0)
+	}
+	return
+}
+
+func (r RawSExpr) String() string {
+	return string(r)
+}
+
+func (r RawSExpr) AppendTo(sb *strings.Builder) {
+	sb.Write(r)
+}
+
+// DecodeRaw validates the next top-level list and returns its encoding without decoding it.
+func (d *Decoder) DecodeRaw() (r RawSExpr, err error) {
+	var c byte
+
+	c, err = d.s.ReadByte()
+	if err != nil {
+		return
+	}
+	if c != '(' {
+		err = ErrUnexpectedCharacter
+		return
+	}
+	err = d.s.UnreadByte()
+	if err != nil {
+		return
+	}
+
+	d.clearLabels()
+	defer d.clearLabels()
+
+	d.raw = d.raw[:0]
+	d.rawLabels = 0
+	err = d.skipNode()
+	if err != nil {
+		return
+	}
+
+	r = make(RawSExpr, len(d.raw))
+	copy(r, d.raw)
+	return
+}
+
+func (d *Decoder) isDeferred() bool {
+	return d.deferDepth > 0 && d.depth >= d.deferDepth
+}
+
+// decodeRaw validates the next list or map and captures its encoding in e as KindRaw.
+func (d *Decoder) decodeRaw(e *SExpr) (err error) {
+	d.raw = d.raw[:0]
+	d.rawLabels = len(d.labels)
+	err = d.skipNode()
+	if err != nil {
+		return
+	}
+
+	e.reset()
+	e.kind = KindRaw
+	e.octets = string(d.raw)
+	return
+}
+
+func (d *Decoder) readRaw() (c byte, err error) {
+	c, err = d.s.ReadByte()
+	if err != nil {
+		return
+	}
+
+	d.raw = append(d.raw, c)
+	return
+}
+
+func (d *Decoder) unreadRaw() (err error) {
+	err = d.s.UnreadByte()
+	if err != nil {
+		return
+	}
+
+	d.raw = d.raw[:len(d.raw)-1]
+	return
+}
+
+// skipNode validates the next s-expression and skips over it without decoding it; all bytes consumed are
+// appended to d.raw.
+func (d *Decoder) skipNode() (err error) {
+	var c byte
+	start := len(d.raw)
+	for {
+		c, err = d.readRaw()
+		if err != nil {
+			return
+		}
+		if c == ' ' {
+			// leading whitespace of the outermost s-expression is not recorded:
+			if start == 0 {
+				d.raw = d.raw[:0]
+			}
+			continue
+		}
+
+		break
+	}
+
+	switch c {
+	case '(':
+		return d.skipList()
+	case '{':
+		return d.skipMap()
+	case '@':
+		return d.skipTagged()
+	case '&':
+		return d.skipLabel()
+	case '*':
+		return d.skipReference()
+	default:
+		return d.skipPrimitive(c)
+	}
+}
+
+func (d *Decoder) skipList() (err error) {
+	var c byte
+	for {
+		c, err = d.readRaw()
+		if err != nil {
+			return
+		}
+		if c == ' ' {
+			continue
+		}
+
+		if c == ')' {
+			return
+		}
+
+		err = d.unreadRaw()
+		if err != nil {
+			return
+		}
+
+		err = d.skipNode()
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (d *Decoder) skipMap() (err error) {
+	var c byte
+	for {
+		c, err = d.readRaw()
+		if err != nil {
+			return
+		}
+		if c == ' ' {
+			continue
+		}
+
+		if c == '}' {
+			return
+		}
+
+		if c != '(' {
+			err = ErrUnexpectedCharacter
+			return
+		}
+
+		// key:
+		for {
+			c, err = d.readRaw()
+			if err != nil {
+				return
+			}
+			if c != ' ' {
+				break
+			}
+		}
+		if c == '(' || c == '{' || c == '@' || c == '&' || c == '*' {
+			err = ErrNotPrimitive
+			return
+		}
+		err = d.skipPrimitive(c)
+		if err != nil {
+			return
+		}
+
+		// value:
+		err = d.skipNode()
+		if err != nil {
+			return
+		}
+
+		c, err = d.readRaw()
+		if err != nil {
+			return
+		}
+		if c != ')' {
+			err = ErrUnexpectedCharacter
+			return
+		}
+	}
+}
+
+// skipPrimitive validates and skips over a primitive atom whose first character c has already been read.
+func (d *Decoder) skipPrimitive(c byte) (err error) {
+	if isSymbolStart(c) {
+		return d.skipIdentifier()
+	}
+
+	switch c {
+	case '"':
+		return d.skipString()
+	case '#':
+		return d.skipHexOctets()
+	case '%':
+		return d.skipFloat()
+	case '-':
+		c, err = d.readRaw()
+		if err != nil {
+			return
+		}
+		if c != '$' {
+			err = ErrUnexpectedCharacter
+			return
+		}
+		return d.skipIntB16(1 << 63)
+	case '$':
+		return d.skipIntB16(1<<63 - 1)
+	default:
+		return ErrUnexpectedCharacter
+	}
+}
+
+func (d *Decoder) skipTagged() (err error) {
+	var c byte
+	c, err = d.readRaw()
+	if err != nil {
+		return
+	}
+	if !isSymbolStart(c) {
+		err = ErrUnexpectedCharacter
+		return
+	}
+
+	start := len(d.raw) - 1
+	err = d.skipIdentifier()
+	if err != nil {
+		return
+	}
+	if !IsValidSymbol(string(d.raw[start:])) {
+		err = ErrInvalidTag
+		return
+	}
+
+	c, err = d.readRaw()
+	if err != nil {
+		return
+	}
+	if c != '(' {
+		err = ErrUnexpectedCharacter
+		return
+	}
+
+	c, err = d.readRaw()
+	if err != nil {
+		return
+	}
+	if c == ')' || c == ' ' {
+		err = ErrUnexpectedCharacter
+		return
+	}
+	err = d.unreadRaw()
+	if err != nil {
+		return
+	}
+
+	err = d.skipNode()
+	if err != nil {
+		return
+	}
+
+	c, err = d.readRaw()
+	if err != nil {
+		return
+	}
+	if c != ')' {
+		err = ErrUnexpectedCharacter
+		return
+	}
+	return
+}
+
+func (d *Decoder) skipLabel() (err error) {
+	var n uint64
+	n, err = d.skipHexDigits(math.MaxUint64)
+	if err != nil {
+		return
+	}
+
+	var c byte
+	c, err = d.readRaw()
+	if err != nil {
+		return
+	}
+	if c != '=' {
+		err = ErrUnexpectedCharacter
+		return
+	}
+
+	c, err = d.readRaw()
+	if err != nil {
+		return
+	}
+	if c == ' ' || c == ')' || c == '}' {
+		err = ErrUnexpectedCharacter
+		return
+	}
+	err = d.unreadRaw()
+	if err != nil {
+		return
+	}
+
+	d.labels, err = defineLabel(d.labels, n)
+	if err != nil {
+		return
+	}
+	i := len(d.labels) - 1
+
+	err = d.skipNode()
+	if err != nil {
+		return
+	}
+
+	d.labels[i].e = deferredLabel
+	return
+}
+
+// skipReference validates a reference which must refer to a label defined within the raw expression itself so
+// that the raw expression can later be decoded on its own.
+func (d *Decoder) skipReference() (err error) {
+	var n uint64
+	n, err = d.skipHexDigits(math.MaxUint64)
+	if err != nil {
+		return
+	}
+
+	e, ok := findLabel(d.labels[d.rawLabels:], n)
+	if !ok {
+		err = ErrUndefinedReference
+		return
+	}
+	if e == pendingLabel {
+		err = ErrReferenceCycle
+		return
+	}
+	return
+}
+
+func (d *Decoder) skipIdentifier() (err error) {
+	var c byte
+	for {
+		c, err = d.readRaw()
+		if err != nil {
+			return
+		}
+		if !isSymbolChar(c) {
+			return d.unreadRaw()
+		}
+	}
+}
+
+// skipHexDigits skips over hex digits and returns their value, failing if there are none or if the value
+// exceeds max.
+func (d *Decoder) skipHexDigits(max uint64) (v uint64, err error) {
+	var c byte
+	n := 0
+	for {
+		c, err = d.readRaw()
+		if err != nil {
+			return
+		}
+		if !isHexDigit(c) {
+			break
+		}
+
+		n++
+		x := hexDigitValue(c)
+		if v > (max-x)>>4 {
+			err = strconv.ErrRange
+			return
+		}
+		v = v<<4 | x
+	}
+
+	if n == 0 {
+		err = ErrUnexpectedCharacter
+		return
+	}
+
+	err = d.unreadRaw()
+	return
+}
+
+func (d *Decoder) skipIntB16(max uint64) (err error) {
+	_, err = d.skipHexDigits(max)
+	return
+}
+
+func (d *Decoder) skipHexOctets() (err error) {
+	var size uint64
+	size, err = d.skipHexDigits(1<<63 - 1)
+	if err != nil {
+		return
+	}
+
+	var c byte
+	c, err = d.readRaw()
+	if err != nil {
+		return
+	}
+	if c != '$' {
+		err = ErrUnexpectedCharacter
+		return
+	}
+
+	for i := uint64(0); i < size*2; i++ {
+		c, err = d.readRaw()
+		if err != nil {
+			return
+		}
+		if !isHexDigit(c) {
+			err = ErrUnexpectedCharacter
+			return
+		}
+	}
+	return
+}
+
+func (d *Decoder) skipFloat() (err error) {
+	var v uint64
+	var c byte
+	for i := 0; i < 16; i++ {
+		c, err = d.readRaw()
+		if err != nil {
+			return
+		}
+		if !isHexDigit(c) {
+			err = ErrUnexpectedCharacter
+			return
+		}
+		v = v<<4 | hexDigitValue(c)
+	}
+
+	c, err = d.readRaw()
+	if err != nil {
+		return
+	}
+	if isHexDigit(c) {
+		err = ErrUnexpectedCharacter
+		return
+	}
+	err = d.unreadRaw()
+	if err != nil {
+		return
+	}
+
+	if !isCanonicalFloatBits(v) {
+		err = ErrNonCanonicalFloat
+		return
+	}
+	return
+}
+
+func (d *Decoder) skipString() (err error) {
+	var c byte
+	for {
+		c, err = d.readRaw()
+		if err != nil {
+			return
+		}
+
+		if c == '\r' || c == '\n' {
+			err = ErrUnexpectedCharacter
+			return
+		}
+
+		if c == '"' {
+			return
+		}
+
+		if c == '\\' {
+			c, err = d.readRaw()
+			if err != nil {
+				return
+			}
+
+			// other escapes are a single character:
+			if c == 'x' {
+				for i := 0; i < 2; i++ {
+					c, err = d.readRaw()
+					if err != nil {
+						return
+					}
+					if !isHexDigit(c) {
+						err = ErrUnexpectedCharacter
+						return
+					}
+				}
+			}
+		}
+	}
+}
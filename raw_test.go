@@ -0,0 +1,144 @@
+package brass
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestDecoder_DeferNested(t *testing.T) {
+	tests := []struct {
+		name    string
+		depth   int
+		s       string
+		wantE   *SExpr
+		wantErr bool
+	}{
+		{
+			name:  `("read" ($1 $2) {("a" $1)})`,
+			depth: 1,
+			s:     `("read" ($1 $2) {("a" $1)})`,
+			wantE: MakeList([]*SExpr{
+				MakeString("read"),
+				MakeRaw(RawSExpr(`($1 $2)`)),
+				MakeRaw(RawSExpr(`{("a" $1)}`)),
+			}),
+		},
+		{
+			name:  `("read" ($1 ($2 "x\\\")")))`,
+			depth: 2,
+			s:     `("read" ($1 ($2 "x\\\")")))`,
+			wantE: MakeList([]*SExpr{
+				MakeString("read"),
+				{
+					kind: KindList,
+					list: []*SExpr{
+						MakeInt64(1),
+						MakeRaw(RawSExpr(`($2 "x\\\")")`)),
+					},
+				},
+			}),
+		},
+		{
+			name:  `("a" {("k" ( $1  #2$0102 ))})`,
+			depth: 1,
+			s:     `("a" {("k" ( $1  #2$0102 ))})`,
+			wantE: MakeList([]*SExpr{
+				MakeString("a"),
+				MakeRaw(RawSExpr(`{("k" ( $1  #2$0102 ))}`)),
+			}),
+		},
+		{
+			name:    `("a" ($1 "\xg0"))`,
+			depth:   1,
+			s:       `("a" ($1 "\xg0"))`,
+			wantErr: true,
+		},
+		{
+			name:    `("a" {(($1) $1)})`,
+			depth:   1,
+			s:       `("a" {(($1) $1)})`,
+			wantErr: true,
+		},
+		{
+			name:    `("a" (#3$0102))`,
+			depth:   1,
+			s:       `("a" (#3$0102))`,
+			wantErr: true,
+		},
+		{
+			name:    `("a" ($10000000000000000))`,
+			depth:   1,
+			s:       `("a" ($10000000000000000))`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDecoder(bytes.NewBufferString(tt.s))
+			d.DeferNested(tt.depth)
+			gotE, err := d.Decode()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Decode() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(gotE, tt.wantE) {
+				t.Errorf("Decode() gotE = %v, want %v", gotE, tt.wantE)
+			}
+			if got := gotE.String(); got != tt.s {
+				t.Errorf("String() = %v, want %v", got, tt.s)
+			}
+		})
+	}
+}
+
+func TestRawSExpr_Decode(t *testing.T) {
+	d := NewDecoder(bytes.NewBufferString(`("a" ($1 {("b" true)}))`))
+	r, err := d.DecodeRaw()
+	if err != nil {
+		t.Fatalf("DecodeRaw() error = %v", err)
+	}
+	if got, want := r.String(), `("a" ($1 {("b" true)}))`; got != want {
+		t.Fatalf("DecodeRaw() = %v, want %v", got, want)
+	}
+
+	e, err := r.Decode()
+	if err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	wantE := MakeList([]*SExpr{
+		MakeString("a"),
+		{
+			kind: KindList,
+			list: []*SExpr{
+				MakeInt64(1),
+				{
+					kind: KindMap,
+					dict: map[SExprPrimitive]*SExpr{
+						PrimitiveString("b"): MakeBool(true),
+					},
+				},
+			},
+		},
+	})
+	if !reflect.DeepEqual(e, wantE) {
+		t.Fatalf("Decode() = %v, want %v", e, wantE)
+	}
+
+	if _, err = RawSExpr(`($1) $2`).Decode(); err == nil {
+		t.Fatalf("Decode() of trailing data must fail")
+	}
+}
+
+func TestSExpr_Expand(t *testing.T) {
+	e := MakeRaw(RawSExpr(`($1 "a")`))
+	if err := e.Expand(); err != nil {
+		t.Fatalf("Expand() error = %v", err)
+	}
+	if e.Kind() != KindList || len(e.AsList()) != 2 || e.AsList()[1].AsString() != "a" {
+		t.Fatalf("Expand() = %v", e)
+	}
+}
@@ -0,0 +1,287 @@
+package brass
+
+import (
+	"errors"
+	"sort"
+)
+
+var ErrLabelOrder = errors.New("labels must be defined in increasing order")
+var ErrUndefinedReference = errors.New("reference to undefined label")
+var ErrReferenceCycle = errors.New("reference to label within its own definition")
+var ErrExpansionLimit = errors.New("expansion of references exceeds limit")
+
+// DefaultMaxExpansion is the default limit on the number of nodes in a decoded tree when every reference is
+// counted as a full copy of the labeled expression it refers to.
+const DefaultMaxExpansion = 1 << 20
+
+// kindReleased marks nodes released to a Decoder so that nodes shared by references are only released once.
+const kindReleased Kind = -1
+
+// label associates a label number with the expression it labels.
+type label struct {
+	n uint64
+	e *SExpr
+}
+
+// sentinel expressions of labels:
+var (
+	// pendingLabel marks a label whose expression is still being decoded:
+	pendingLabel = &SExpr{}
+	// deferredLabel marks a label defined within a deferred raw expression:
+	deferredLabel = &SExpr{}
+)
+
+// SetMaxExpansion sets the limit on the number of nodes in a decoded tree when every reference is counted as a
+// full copy of the expression it refers to. Decoding fails with ErrExpansionLimit when the limit is exceeded.
+// This protects consumers that traverse decoded trees from inputs that are small on the wire but which expand
+// exponentially through nested references. A limit of 0 selects DefaultMaxExpansion.
+func (d *Decoder) SetMaxExpansion(n int) {
+	d.maxExpansion = n
+}
+
+// clearLabels forgets all labels while retaining storage.
+func (d *Decoder) clearLabels() {
+	for i := range d.labels {
+		d.labels[i] = label{}
+	}
+	d.labels = d.labels[:0]
+	d.ref = nil
+}
+
+// shared returns the labeled expression if a reference was decoded in place of e or else e itself.
+func (d *Decoder) shared(e *SExpr) *SExpr {
+	if d.ref == nil {
+		return e
+	}
+
+	r := d.ref
+	d.ref = nil
+
+	// e was not used:
+	e.kind = kindReleased
+	d.free = append(d.free, e)
+	return r
+}
+
+// defineLabel appends a pending label n which must be greater than all labels defined before it.
+func defineLabel(labels []label, n uint64) ([]label, error) {
+	if k := len(labels); k > 0 && n <= labels[k-1].n {
+		return labels, ErrLabelOrder
+	}
+	return append(labels, label{n: n, e: pendingLabel}), nil
+}
+
+// findLabel returns the expression labeled n.
+func findLabel(labels []label, n uint64) (e *SExpr, ok bool) {
+	// labels are sorted as they are defined in increasing order:
+	i := sort.Search(len(labels), func(i int) bool { return labels[i].n >= n })
+	if i == len(labels) || labels[i].n != n {
+		return
+	}
+
+	e, ok = labels[i].e, true
+	return
+}
+
+// resolveLabel returns the expression for a reference to label n.
+func resolveLabel(labels []label, n uint64) (e *SExpr, err error) {
+	var ok bool
+	e, ok = findLabel(labels, n)
+	if !ok || e == deferredLabel {
+		// references may not refer into deferred expressions:
+		e = nil
+		err = ErrUndefinedReference
+		return
+	}
+	if e == pendingLabel {
+		e = nil
+		err = ErrReferenceCycle
+		return
+	}
+	return
+}
+
+// checkExpansion fails if the tree rooted at e exceeds max nodes with every reference expanded.
+func checkExpansion(e *SExpr, labels []label, max int) (err error) {
+	if max <= 0 {
+		max = DefaultMaxExpansion
+	}
+
+	if expansionOf(e, labels, max) > max {
+		err = ErrExpansionLimit
+	}
+	return
+}
+
+// expansionOf returns the number of nodes of the tree rooted at e with every reference expanded, stopping early
+// once max is exceeded.
+func expansionOf(e *SExpr, labels []label, max int) int {
+	// the sizes of labeled expressions are memoized so that shared subtrees are only traversed once:
+	memo := make(map[*SExpr]int, len(labels))
+	for _, l := range labels {
+		memo[l.e] = -1
+	}
+
+	return expandedSize(e, memo, max)
+}
+
+// expandedSize counts the nodes of the tree rooted at e, stopping early once max is exceeded.
+func expandedSize(e *SExpr, memo map[*SExpr]int, max int) (n int) {
+	m, labeled := memo[e]
+	if labeled && m >= 0 {
+		return m
+	}
+
+	n = 1
+	switch e.kind {
+	case KindList:
+		for _, c := range e.list {
+			n += expandedSize(c, memo, max)
+			if n > max {
+				return
+			}
+		}
+	case KindMap:
+		if e.omap != nil {
+			for _, en := range e.omap.entries {
+				n += expandedSize(en.Value, memo, max)
+				if n > max {
+					return
+				}
+			}
+		} else {
+			for _, v := range e.dict {
+				n += expandedSize(v, memo, max)
+				if n > max {
+					return
+				}
+			}
+		}
+	case KindTagged:
+		n += expandedSize(e.tagged, memo, max)
+	}
+
+	if labeled {
+		memo[e] = n
+	}
+	return
+}
+
+// AppendBrassShared is like AppendBrass but encodes each list, map, tagged value, string or octets node that
+// appears more than once in the tree only once, labeling its first occurrence and referring back to the label
+// at every other occurrence. Labels are numbered in order of first occurrence. ErrReferenceCycle is returned if
+// a node contains itself.
+func (e *SExpr) AppendBrassShared(dst []byte) ([]byte, error) {
+	enc := sharedEncoder{
+		counts: make(map[*SExpr]int),
+		labels: make(map[*SExpr]int),
+		active: make(map[*SExpr]bool),
+	}
+	enc.count(e)
+	return enc.append(dst, e)
+}
+
+type sharedEncoder struct {
+	// counts is the number of occurrences of each shareable node:
+	counts map[*SExpr]int
+	// labels assigned to nodes already encoded:
+	labels map[*SExpr]int
+	// active is the set of nodes currently being encoded:
+	active map[*SExpr]bool
+}
+
+func isShareable(e *SExpr) bool {
+	switch e.kind {
+	case KindList, KindMap, KindTagged, KindString, KindOctets:
+		return true
+	default:
+		return false
+	}
+}
+
+func (enc *sharedEncoder) count(e *SExpr) {
+	if isShareable(e) {
+		enc.counts[e]++
+		if enc.counts[e] > 1 {
+			// children already counted:
+			return
+		}
+	}
+
+	switch e.kind {
+	case KindList:
+		for _, c := range e.list {
+			enc.count(c)
+		}
+	case KindMap:
+		for _, en := range mapEntries(e) {
+			enc.count(en.Value)
+		}
+	case KindTagged:
+		enc.count(e.tagged)
+	}
+}
+
+func (enc *sharedEncoder) append(dst []byte, e *SExpr) (_ []byte, err error) {
+	if enc.counts[e] > 1 {
+		if enc.active[e] {
+			return dst, ErrReferenceCycle
+		}
+		if label, ok := enc.labels[e]; ok {
+			dst = append(dst, '*')
+			return appendHex(dst, uint64(label)), nil
+		}
+
+		label := len(enc.labels)
+		enc.labels[e] = label
+		dst = append(dst, '&')
+		dst = appendHex(dst, uint64(label))
+		dst = append(dst, '=')
+	}
+
+	enc.active[e] = true
+	defer delete(enc.active, e)
+
+	switch e.kind {
+	case KindList:
+		dst = append(dst, '(')
+		for i, c := range e.list {
+			if i > 0 {
+				dst = append(dst, ' ')
+			}
+			dst, err = enc.append(dst, c)
+			if err != nil {
+				return dst, err
+			}
+		}
+		return append(dst, ')'), nil
+	case KindMap:
+		dst = append(dst, '{')
+		for i, en := range mapEntries(e) {
+			if i > 0 {
+				dst = append(dst, ' ')
+			}
+
+			dst = append(dst, '(')
+			dst = appendPrimitive(dst, en.Key.kind, en.Key.integer, en.Key.octets)
+			dst = append(dst, ' ')
+			dst, err = enc.append(dst, en.Value)
+			if err != nil {
+				return dst, err
+			}
+			dst = append(dst, ')')
+		}
+		return append(dst, '}'), nil
+	case KindTagged:
+		dst = append(dst, '@')
+		dst = append(dst, e.octets...)
+		dst = append(dst, '(')
+		dst, err = enc.append(dst, e.tagged)
+		if err != nil {
+			return dst, err
+		}
+		return append(dst, ')'), nil
+	default:
+		return e.AppendBrass(dst), nil
+	}
+}
@@ -0,0 +1,275 @@
+package brass
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecoder_References(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		wantErr error
+	}{
+		{
+			name: "list",
+			s:    `(&0=("tiles" #4$00010203) *0 *0)`,
+		},
+		{
+			name: "map values",
+			s:    `({("a" &0=($1 $2)) ("b" *0)} *0)`,
+		},
+		{
+			name: "tagged",
+			s:    `(&0=@point(($1 $2)) @wrap(*0))`,
+		},
+		{
+			name: "nested labels",
+			s:    `(&0=(&1="x" *1) *0 *1)`,
+		},
+		{
+			name: "label a reference",
+			s:    `(&0="x" &1=*0 *1)`,
+		},
+		{
+			name: "sparse label numbers",
+			s:    `(&3="x" &a="y" *3 *a)`,
+		},
+		{
+			name:    "undefined",
+			s:       `(&0="x" *1)`,
+			wantErr: ErrUndefinedReference,
+		},
+		{
+			name:    "forward",
+			s:       `(*0 &0="x")`,
+			wantErr: ErrUndefinedReference,
+		},
+		{
+			name:    "cycle",
+			s:       `(&0=($1 *0))`,
+			wantErr: ErrReferenceCycle,
+		},
+		{
+			name:    "order",
+			s:       `(&1="x" &0="y")`,
+			wantErr: ErrLabelOrder,
+		},
+		{
+			name:    "duplicate",
+			s:       `(&0="x" &0="y")`,
+			wantErr: ErrLabelOrder,
+		},
+		{
+			name:    "map key label",
+			s:       `({(&0="a" $1)})`,
+			wantErr: ErrNotPrimitive,
+		},
+		{
+			name:    "map key reference",
+			s:       `(&0="a" {(*0 $1)})`,
+			wantErr: ErrNotPrimitive,
+		},
+		{
+			name:    "whitespace after label",
+			s:       `(&0= "x")`,
+			wantErr: ErrUnexpectedCharacter,
+		},
+		{
+			name:    "missing label expression",
+			s:       `(&0=)`,
+			wantErr: ErrUnexpectedCharacter,
+		},
+		{
+			name:    "missing label number",
+			s:       `(*)`,
+			wantErr: ErrUnexpectedCharacter,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDecoder(bytes.NewReader([]byte(tt.s)))
+			e, err := d.Decode()
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Decode() error = %v, want %v", err, tt.wantErr)
+			}
+
+			// the fast path and the raw validator must agree:
+			_, _, err = DecodeBytes([]byte(tt.s))
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("DecodeBytes() error = %v, want %v", err, tt.wantErr)
+			}
+			_, err = NewDecoder(bytes.NewReader([]byte(tt.s))).DecodeRaw()
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("DecodeRaw() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+
+			// round-trip through the shared encoding:
+			b, err := e.AppendBrassShared(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			d = NewDecoder(bytes.NewReader(b))
+			e2, err := d.Decode()
+			if err != nil {
+				t.Fatalf("Decode(%s) error = %v", b, err)
+			}
+			if !reflect.DeepEqual(e2, e) {
+				t.Errorf("round-trip = %s, want %s", e2, e)
+			}
+		})
+	}
+}
+
+func TestDecoder_ReferencesShared(t *testing.T) {
+	d := NewDecoder(bytes.NewReader([]byte(`(&0=("tiles" #4$00010203) *0 {("a" *0)})`)))
+	e, err := d.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	list := e.AsList()
+	if list[0] != list[1] || list[0] != list[2].AsMap()[PrimitiveString("a")] {
+		t.Error("references must resolve to the labeled expression")
+	}
+	if got, want := e.String(), `(("tiles" #4$00010203) ("tiles" #4$00010203) {("a" ("tiles" #4$00010203))})`; got != want {
+		t.Errorf("String() = %s, want %s", got, want)
+	}
+
+	b, _, err := DecodeBytes([]byte(`(&0=($1) *0)`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.AsList()[0] != b.AsList()[1] {
+		t.Error("DecodeBytes references must resolve to the labeled expression")
+	}
+
+	// shared nodes must only be released once:
+	d.Release(e)
+	seen := make(map[*SExpr]bool)
+	for _, n := range d.free {
+		if seen[n] {
+			t.Fatal("node released more than once")
+		}
+		seen[n] = true
+	}
+}
+
+func TestDecoder_ReferencesDeferred(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		wantErr error
+	}{
+		{
+			name: "within deferred",
+			s:    `(&0=$1 (&1=($2) *1))`,
+		},
+		{
+			name:    "into deferred",
+			s:       `((&0=($2)) *0)`,
+			wantErr: ErrUndefinedReference,
+		},
+		{
+			name:    "out of deferred",
+			s:       `(&0=$1 (*0))`,
+			wantErr: ErrUndefinedReference,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDecoder(bytes.NewReader([]byte(tt.s)))
+			d.DeferNested(1)
+			e, err := d.Decode()
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Decode() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			// deferred expressions must decode on their own:
+			for _, c := range e.AsList() {
+				if c.Kind() != KindRaw {
+					continue
+				}
+				if _, err = c.AsRaw().Decode(); err != nil {
+					t.Errorf("RawSExpr.Decode(%s) error = %v", c.AsRaw(), err)
+				}
+			}
+		})
+	}
+}
+
+func TestDecoder_ExpansionLimit(t *testing.T) {
+	// each label doubles the expanded size of the previous one:
+	sb := strings.Builder{}
+	sb.WriteString("(&0=($1 $2)")
+	for i := 1; i < 40; i++ {
+		fmt.Fprintf(&sb, " &%x=(*%x *%x)", i, i-1, i-1)
+	}
+	sb.WriteString(")")
+	s := sb.String()
+
+	if _, err := NewDecoder(strings.NewReader(s)).Decode(); !errors.Is(err, ErrExpansionLimit) {
+		t.Errorf("Decode() error = %v, want %v", err, ErrExpansionLimit)
+	}
+	if _, _, err := DecodeBytes([]byte(s)); !errors.Is(err, ErrExpansionLimit) {
+		t.Errorf("DecodeBytes() error = %v, want %v", err, ErrExpansionLimit)
+	}
+
+	d := NewDecoder(strings.NewReader(`(&0=($1 $2) &1=(*0 *0) &2=(*1 *1))`))
+	d.SetMaxExpansion(26)
+	if _, err := d.Decode(); err != nil {
+		t.Errorf("Decode() error = %v", err)
+	}
+	d.Reset(strings.NewReader(`(&0=($1 $2) &1=(*0 *0) &2=(*1 *1))`))
+	d.SetMaxExpansion(25)
+	if _, err := d.Decode(); !errors.Is(err, ErrExpansionLimit) {
+		t.Errorf("Decode() error = %v, want %v", err, ErrExpansionLimit)
+	}
+}
+
+func TestSExpr_AppendBrassShared(t *testing.T) {
+	tiles := MakeOctets([]byte{0, 1, 2, 3})
+	sprite := MakeList([]*SExpr{MakeString("sprite"), tiles})
+	e := MakeList([]*SExpr{
+		sprite,
+		sprite,
+		MakeMap(map[SExprPrimitive]*SExpr{
+			PrimitiveString("a"): tiles,
+			PrimitiveString("b"): MakeInt64(1),
+		}),
+		MakeInt64(1),
+	})
+
+	b, err := e.AppendBrassShared(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(b), `(&0=("sprite" &1=#4$00010203) *0 {("a" *1) ("b" $1)} $1)`; got != want {
+		t.Errorf("AppendBrassShared() = %s, want %s", got, want)
+	}
+
+	// trees without shared nodes encode as AppendBrass:
+	b, err = sprite.AppendBrassShared(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(b), sprite.String(); got != want {
+		t.Errorf("AppendBrassShared() = %s, want %s", got, want)
+	}
+
+	cyclic := MakeList([]*SExpr{MakeInt64(1)})
+	cyclic.list = append(cyclic.list, cyclic)
+	if _, err = cyclic.AppendBrassShared(nil); !errors.Is(err, ErrReferenceCycle) {
+		t.Errorf("AppendBrassShared() error = %v, want %v", err, ErrReferenceCycle)
+	}
+}
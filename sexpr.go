@@ -2,6 +2,8 @@ package brass
 
 import (
 	"fmt"
+	"math"
+	"strconv"
 	"strings"
 )
 
@@ -16,8 +18,33 @@ const (
 	KindOctets
 	KindList
 	KindMap
+	KindRaw
+	KindSymbol
+	KindFloat
+	KindTagged
 )
 
+var kindNames = [...]string{
+	KindNil:     "KindNil",
+	KindBool:    "KindBool",
+	KindInteger: "KindInteger",
+	KindString:  "KindString",
+	KindOctets:  "KindOctets",
+	KindList:    "KindList",
+	KindMap:     "KindMap",
+	KindRaw:     "KindRaw",
+	KindSymbol:  "KindSymbol",
+	KindFloat:   "KindFloat",
+	KindTagged:  "KindTagged",
+}
+
+func (k Kind) String() string {
+	if k < 0 || int(k) >= len(kindNames) || kindNames[k] == "" {
+		return "Kind(" + strconv.Itoa(int(k)) + ")"
+	}
+	return kindNames[k]
+}
+
 type AppendableTo interface {
 	AppendTo(sb *strings.Builder)
 }
@@ -28,6 +55,9 @@ type SExpr struct {
 	octets  string
 	list    []*SExpr
 	dict    map[SExprPrimitive]*SExpr
+	omap    *OrderedMap
+	tagged  *SExpr
+	value   any
 }
 
 func (e *SExpr) Kind() Kind { return e.kind }
@@ -51,6 +81,14 @@ func (e *SExpr) AsInt64() int64 {
 	return e.integer
 }
 
+func (e *SExpr) AsFloat64() float64 {
+	kind := e.kind
+	if kind != KindFloat {
+		panic("must be KindFloat")
+	}
+	return math.Float64frombits(uint64(e.integer))
+}
+
 func (e *SExpr) AsString() string {
 	kind := e.kind
 	if kind != KindString {
@@ -67,6 +105,14 @@ func (e *SExpr) AsOctets() []byte {
 	return []byte(e.octets)
 }
 
+func (e *SExpr) AsSymbol() string {
+	kind := e.kind
+	if kind != KindSymbol {
+		panic("must be KindSymbol")
+	}
+	return e.octets
+}
+
 func (e *SExpr) AsList() []*SExpr {
 	if e.kind != KindList {
 		panic("must be KindList")
@@ -74,18 +120,92 @@ func (e *SExpr) AsList() []*SExpr {
 	return e.list
 }
 
+// AsMap returns the Go map of a map so that changes to it are made to the expression. An ordered map is left
+// unchanged and a new Go map of its entries is returned instead; use AsOrderedMap to change an ordered map.
 func (e *SExpr) AsMap() map[SExprPrimitive]*SExpr {
 	if e.kind != KindMap {
 		panic("must be KindMap")
 	}
+	if e.omap != nil {
+		return e.omap.toMap()
+	}
 	return e.dict
 }
 
+// IsOrderedMap returns true if the expression is a map that preserves the order of its entries.
+func (e *SExpr) IsOrderedMap() bool {
+	return e.kind == KindMap && e.omap != nil
+}
+
+// AsOrderedMap returns the OrderedMap of an ordered map so that changes to it are made to the expression. An
+// unordered map is first converted in place to an ordered map with its entries in ascending key order; the Go map
+// previously returned by AsMap is no longer used by the expression.
+func (e *SExpr) AsOrderedMap() *OrderedMap {
+	if e.kind != KindMap {
+		panic("must be KindMap")
+	}
+	if e.omap != nil {
+		return e.omap
+	}
+
+	m := NewOrderedMap(len(e.dict))
+	for _, k := range sortedKeys(e.dict) {
+		m.Set(k, e.dict[k])
+	}
+	e.omap = m
+	e.dict = nil
+	return m
+}
+
+// AsTagged returns the tag and inner expression of a tagged value.
+func (e *SExpr) AsTagged() (tag string, inner *SExpr) {
+	if e.kind != KindTagged {
+		panic("must be KindTagged")
+	}
+	return e.octets, e.tagged
+}
+
+// TaggedValue returns the Go value converted from a tagged value by its registered codec or nil if its tag
+// was not registered.
+func (e *SExpr) TaggedValue() any {
+	if e.kind != KindTagged {
+		panic("must be KindTagged")
+	}
+	return e.value
+}
+
+// AsRaw returns the undecoded encoding of a KindRaw expression.
+func (e *SExpr) AsRaw() RawSExpr {
+	if e.kind != KindRaw {
+		panic("must be KindRaw")
+	}
+	return RawSExpr(e.octets)
+}
+
+// Expand decodes a KindRaw expression in place; expressions of any other kind are left as is.
+func (e *SExpr) Expand() (err error) {
+	if e.kind != KindRaw {
+		return
+	}
+
+	var x *SExpr
+	x, err = RawSExpr(e.octets).Decode()
+	if err != nil {
+		return
+	}
+
+	*e = *x
+	return
+}
+
 func (e *SExpr) reset() {
 	e.integer = 0
 	e.octets = ""
 	e.list = nil
 	e.dict = nil
+	e.omap = nil
+	e.tagged = nil
+	e.value = nil
 }
 
 func (e *SExpr) SetNil() {
@@ -124,6 +244,21 @@ func (e *SExpr) SetOctets(octets string) {
 	e.octets = octets
 }
 
+// SetFloat64 sets a float value; all NaN values are canonicalized to a single quiet NaN.
+func (e *SExpr) SetFloat64(value float64) {
+	e.reset()
+	e.kind = KindFloat
+	e.integer = floatBits(value)
+}
+
+// SetSymbol sets a symbol. It panics if name is not a valid symbol.
+func (e *SExpr) SetSymbol(name string) {
+	mustBeSymbol(name)
+	e.reset()
+	e.kind = KindSymbol
+	e.octets = name
+}
+
 func (e *SExpr) SetInt64(value int64) {
 	e.reset()
 	e.kind = KindInteger
@@ -131,20 +266,13 @@ func (e *SExpr) SetInt64(value int64) {
 }
 
 func (e *SExpr) String() string {
-	sb := strings.Builder{}
-	e.AppendTo(&sb)
-	return sb.String()
+	return bytesToString(e.AppendBrass(make([]byte, 0, e.EncodedLen())))
 }
 
 func (e *SExpr) AppendTo(sb *strings.Builder) {
 	switch e.kind {
-	case KindNil, KindBool, KindInteger, KindOctets, KindString:
-		(&SExprPrimitive{
-			kind:    e.kind,
-			integer: e.integer,
-			octets:  e.octets,
-		}).AppendTo(sb)
-		return
+	case KindNil, KindBool, KindInteger, KindFloat, KindOctets, KindString, KindSymbol:
+		writePrimitive(sb, e.kind, e.integer, e.octets)
 	case KindList:
 		sb.WriteByte('(')
 		for i, c := range e.list {
@@ -154,9 +282,23 @@ func (e *SExpr) AppendTo(sb *strings.Builder) {
 			c.AppendTo(sb)
 		}
 		sb.WriteByte(')')
-		return
 	case KindMap:
 		sb.WriteByte('{')
+		if e.omap != nil {
+			for i, en := range e.omap.entries {
+				if i > 0 {
+					sb.WriteByte(' ')
+				}
+
+				sb.WriteByte('(')
+				writePrimitive(sb, en.Key.kind, en.Key.integer, en.Key.octets)
+				sb.WriteByte(' ')
+				en.Value.AppendTo(sb)
+				sb.WriteByte(')')
+			}
+			sb.WriteByte('}')
+			return
+		}
 		addSpace := false
 		for k, v := range e.dict {
 			if addSpace {
@@ -165,13 +307,119 @@ func (e *SExpr) AppendTo(sb *strings.Builder) {
 			addSpace = true
 
 			sb.WriteByte('(')
-			k.AppendTo(sb)
+			writePrimitive(sb, k.kind, k.integer, k.octets)
 			sb.WriteByte(' ')
 			v.AppendTo(sb)
 			sb.WriteByte(')')
 		}
 		sb.WriteByte('}')
-		return
+	case KindTagged:
+		sb.WriteByte('@')
+		sb.WriteString(e.octets)
+		sb.WriteByte('(')
+		e.tagged.AppendTo(sb)
+		sb.WriteByte(')')
+	case KindRaw:
+		sb.WriteString(e.octets)
+	default:
+		panic(fmt.Errorf("unimplemented kind"))
+	}
+}
+
+// AppendBrass appends the encoding of the expression to dst and returns the extended slice. It does not
+// allocate if dst has sufficient capacity; use EncodedLen to size dst up front.
+func (e *SExpr) AppendBrass(dst []byte) []byte {
+	switch e.kind {
+	case KindNil, KindBool, KindInteger, KindFloat, KindOctets, KindString, KindSymbol:
+		return appendPrimitive(dst, e.kind, e.integer, e.octets)
+	case KindList:
+		dst = append(dst, '(')
+		for i, c := range e.list {
+			if i > 0 {
+				dst = append(dst, ' ')
+			}
+			dst = c.AppendBrass(dst)
+		}
+		return append(dst, ')')
+	case KindMap:
+		dst = append(dst, '{')
+		if e.omap != nil {
+			for i, en := range e.omap.entries {
+				if i > 0 {
+					dst = append(dst, ' ')
+				}
+
+				dst = append(dst, '(')
+				dst = appendPrimitive(dst, en.Key.kind, en.Key.integer, en.Key.octets)
+				dst = append(dst, ' ')
+				dst = en.Value.AppendBrass(dst)
+				dst = append(dst, ')')
+			}
+			return append(dst, '}')
+		}
+		addSpace := false
+		for k, v := range e.dict {
+			if addSpace {
+				dst = append(dst, ' ')
+			}
+			addSpace = true
+
+			dst = append(dst, '(')
+			dst = appendPrimitive(dst, k.kind, k.integer, k.octets)
+			dst = append(dst, ' ')
+			dst = v.AppendBrass(dst)
+			dst = append(dst, ')')
+		}
+		return append(dst, '}')
+	case KindTagged:
+		dst = append(dst, '@')
+		dst = append(dst, e.octets...)
+		dst = append(dst, '(')
+		dst = e.tagged.AppendBrass(dst)
+		return append(dst, ')')
+	case KindRaw:
+		return append(dst, e.octets...)
+	default:
+		panic(fmt.Errorf("unimplemented kind"))
+	}
+}
+
+// EncodedLen returns the exact length in bytes of the encoding of the expression.
+func (e *SExpr) EncodedLen() int {
+	switch e.kind {
+	case KindNil, KindBool, KindInteger, KindFloat, KindOctets, KindString, KindSymbol:
+		return primitiveEncodedLen(e.kind, e.integer, e.octets)
+	case KindList:
+		n := 2
+		for i, c := range e.list {
+			if i > 0 {
+				n++
+			}
+			n += c.EncodedLen()
+		}
+		return n
+	case KindMap:
+		n := 2
+		if e.omap != nil {
+			if len(e.omap.entries) > 0 {
+				n += len(e.omap.entries) - 1
+			}
+			for _, en := range e.omap.entries {
+				n += 3 + primitiveEncodedLen(en.Key.kind, en.Key.integer, en.Key.octets) + en.Value.EncodedLen()
+			}
+			return n
+		}
+		if len(e.dict) > 0 {
+			n += len(e.dict) - 1
+		}
+		for k, v := range e.dict {
+			n += 3 + primitiveEncodedLen(k.kind, k.integer, k.octets) + v.EncodedLen()
+		}
+		return n
+	case KindTagged:
+		return 3 + len(e.octets) + e.tagged.EncodedLen()
+	case KindRaw:
+		return len(e.octets)
 	default:
 		panic(fmt.Errorf("unimplemented kind"))
 	}
@@ -186,7 +434,18 @@ func MakeBool(v bool) *SExpr {
 	}
 }
 func MakeInt64(v int64) *SExpr                   { return &SExpr{kind: KindInteger, integer: v} }
+func MakeFloat64(v float64) *SExpr               { return &SExpr{kind: KindFloat, integer: floatBits(v)} }
 func MakeString(v string) *SExpr                 { return &SExpr{kind: KindString, octets: v} }
 func MakeOctets(v []byte) *SExpr                 { return &SExpr{kind: KindOctets, octets: string(v)} }
 func MakeList(v []*SExpr) *SExpr                 { return &SExpr{kind: KindList, list: v} }
 func MakeMap(v map[SExprPrimitive]*SExpr) *SExpr { return &SExpr{kind: KindMap, dict: v} }
+func MakeRaw(v RawSExpr) *SExpr                  { return &SExpr{kind: KindRaw, octets: string(v)} }
+
+// MakeSymbol makes a symbol. It panics if v is not a valid symbol; NewSymbol returns an error instead.
+func MakeSymbol(v string) *SExpr {
+	mustBeSymbol(v)
+	return &SExpr{kind: KindSymbol, octets: v}
+}
+
+// MakeOrderedMap makes a map which encodes its entries in the order they appear in v.
+func MakeOrderedMap(v *OrderedMap) *SExpr { return &SExpr{kind: KindMap, omap: v} }
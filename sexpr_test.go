@@ -1,6 +1,13 @@
 package brass
 
-import "testing"
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+	"testing"
+)
 
 func TestSExpr_String(t *testing.T) {
 	type fields struct {
@@ -90,3 +97,238 @@ func TestSExpr_String(t *testing.T) {
 		})
 	}
 }
+
+func TestSExpr_AppendBrass(t *testing.T) {
+	tests := []struct {
+		name string
+		e    *SExpr
+		want string
+	}{
+		{
+			name: "primitives",
+			e: MakeList([]*SExpr{
+				MakeNil(),
+				MakeBool(true),
+				MakeBool(false),
+				MakeInt64(0),
+				MakeInt64(0x3ff),
+				MakeInt64(-0x3ff),
+				MakeInt64(math.MinInt64),
+				MakeInt64(math.MaxInt64),
+				MakeSymbol("read-mem"),
+			}),
+			want: "(nil true false $0 $3ff -$3ff -$8000000000000000 $7fffffffffffffff read-mem)",
+		},
+		{
+			name: "floats",
+			e: MakeList([]*SExpr{
+				MakeFloat64(1),
+				MakeFloat64(-0.5),
+				MakeFloat64(math.Copysign(0, -1)),
+				MakeFloat64(math.Inf(1)),
+				MakeFloat64(math.Inf(-1)),
+				MakeFloat64(math.NaN()),
+				MakeFloat64(math.Float64frombits(0xfff8000000000123)),
+				MakeFloat64(math.SmallestNonzeroFloat64),
+			}),
+			want: "(%3ff0000000000000 %bfe0000000000000 %8000000000000000 %7ff0000000000000 %fff0000000000000 " +
+				"%7ff8000000000000 %7ff8000000000000 %0000000000000001)",
+		},
+		{
+			name: "octets",
+			e:    MakeList([]*SExpr{MakeOctets([]byte{}), MakeOctets([]byte("\x00\x0f\xf0\xff0123456789a"))}),
+			want: "(#0$ #f$000ff0ff3031323334353637383961)",
+		},
+		{
+			name: "strings",
+			e:    MakeList([]*SExpr{MakeString(""), MakeString("a\\b\"c\r\n\t\x00\x1f\x80\xff ~")}),
+			want: `("" "a\\b\"c\r\n\t\x00\x1f\x80\xff ~")`,
+		},
+		{
+			name: "long",
+			e:    MakeList([]*SExpr{MakeString(strings.Repeat("\x00a", 300)), MakeOctets(bytes.Repeat([]byte{0xab}, 1000))}),
+			want: `("` + strings.Repeat(`\x00a`, 300) + `" #3e8$` + strings.Repeat("ab", 1000) + `)`,
+		},
+		{
+			name: "map",
+			e: MakeMap(map[SExprPrimitive]*SExpr{
+				PrimitiveString("a"): MakeList([]*SExpr{MakeInt64(1)}),
+			}),
+			want: `{("a" ($1))}`,
+		},
+		{
+			name: "raw",
+			e:    MakeList([]*SExpr{MakeRaw(RawSExpr("( $1  $2)"))}),
+			want: "(( $1  $2))",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(tt.e.AppendBrass([]byte("x"))); got != "x"+tt.want {
+				t.Errorf("AppendBrass() = %v, want %v", got, "x"+tt.want)
+			}
+			if got := tt.e.EncodedLen(); got != len(tt.want) {
+				t.Errorf("EncodedLen() = %v, want %v", got, len(tt.want))
+			}
+			if got := tt.e.String(); got != tt.want {
+				t.Errorf("String() = %v, want %v", got, tt.want)
+			}
+			sb := strings.Builder{}
+			sb.WriteString("x")
+			tt.e.AppendTo(&sb)
+			if got := sb.String(); got != "x"+tt.want {
+				t.Errorf("AppendTo() = %v, want %v", got, "x"+tt.want)
+			}
+		})
+	}
+}
+
+func TestPrimitiveInt64(t *testing.T) {
+	for _, v := range []int64{0, 1, -0x10, math.MaxInt64, math.MinInt64} {
+		p := PrimitiveInt64(v)
+		if p.Kind() != KindInteger {
+			t.Fatalf("PrimitiveInt64(%d).Kind() = %v, want %v", v, p.Kind(), KindInteger)
+		}
+		if got := p.AsInt64(); got != v {
+			t.Errorf("PrimitiveInt64(%d).AsInt64() = %v", v, got)
+		}
+		if got, want := string(p.AppendBrass(nil)), MakeInt64(v).String(); got != want {
+			t.Errorf("PrimitiveInt64(%d) encodes as %v, want %v", v, got, want)
+		}
+	}
+}
+
+func benchmarkEncodeExprs() map[string]*SExpr {
+	dump := make([]byte, 32768)
+	rand.Read(dump)
+
+	return map[string]*SExpr{
+		"small": MakeList([]*SExpr{
+			MakeString("read"),
+			MakeInt64(0x7e0010),
+			MakeInt64(-0x10),
+			MakeList([]*SExpr{MakeString("abc\tdef\x00"), MakeBool(true), MakeNil()}),
+		}),
+		"dump": MakeList([]*SExpr{
+			MakeString("wram"),
+			MakeInt64(0x7e0000),
+			MakeOctets(dump),
+		}),
+	}
+}
+
+func BenchmarkSExpr_AppendTo(b *testing.B) {
+	for name, e := range benchmarkEncodeExprs() {
+		sb := strings.Builder{}
+		baselineAppendTo(e, &sb)
+		if sb.String() != e.String() {
+			b.Fatalf("baseline encoding of %s differs", name)
+		}
+
+		b.Run(name+"/AppendTo", func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				sb := strings.Builder{}
+				e.AppendTo(&sb)
+			}
+		})
+		b.Run(name+"/baseline", func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				sb := strings.Builder{}
+				baselineAppendTo(e, &sb)
+			}
+		})
+	}
+}
+
+// baselineAppendTo is the encoder AppendTo replaced, which formats with fmt.Fprintf, kept to compare with it.
+func baselineAppendTo(e *SExpr, sb *strings.Builder) {
+	switch e.kind {
+	case KindNil:
+		sb.WriteString("nil")
+	case KindBool:
+		if e.integer != 0 {
+			sb.WriteString("true")
+		} else {
+			sb.WriteString("false")
+		}
+	case KindInteger:
+		if e.integer < 0 {
+			fmt.Fprintf(sb, "-$%x", -e.integer)
+		} else {
+			fmt.Fprintf(sb, "$%x", e.integer)
+		}
+	case KindOctets:
+		sb.WriteByte('#')
+		fmt.Fprintf(sb, "%x", len(e.octets))
+		sb.WriteByte('$')
+		for _, b := range []byte(e.octets) {
+			fmt.Fprintf(sb, "%02x", b)
+		}
+	case KindString:
+		sb.WriteByte('"')
+		for _, b := range []byte(e.octets) {
+			if b == '\\' {
+				sb.WriteString("\\\\")
+			} else if b == '"' {
+				sb.WriteString("\\\"")
+			} else if b == '\r' {
+				sb.WriteString("\\r")
+			} else if b == '\n' {
+				sb.WriteString("\\n")
+			} else if b == '\t' {
+				sb.WriteString("\\t")
+			} else if b < 32 || b >= 128 {
+				fmt.Fprintf(sb, "\\x%02x", b)
+			} else {
+				sb.WriteByte(b)
+			}
+		}
+		sb.WriteByte('"')
+	case KindList:
+		sb.WriteByte('(')
+		for i, c := range e.list {
+			if i > 0 {
+				sb.WriteByte(' ')
+			}
+			baselineAppendTo(c, sb)
+		}
+		sb.WriteByte(')')
+	default:
+		panic("unimplemented kind")
+	}
+}
+
+func BenchmarkSExpr_AppendBrass(b *testing.B) {
+	for name, e := range benchmarkEncodeExprs() {
+		b.Run(name, func(b *testing.B) {
+			dst := make([]byte, 0, e.EncodedLen())
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				dst = e.AppendBrass(dst[:0])
+			}
+		})
+	}
+}
+
+// basePrimitive implements only the methods of Primitive and MutablePrimitive that existed before floats and
+// symbols were added so that adding methods to them, which breaks other implementations, fails to compile.
+type basePrimitive struct{}
+
+func (basePrimitive) Kind() Kind       { return KindNil }
+func (basePrimitive) IsNil() bool      { return true }
+func (basePrimitive) AsBool() bool     { return false }
+func (basePrimitive) AsInt64() int64   { return 0 }
+func (basePrimitive) AsString() string { return "" }
+func (basePrimitive) AsOctets() []byte { return nil }
+func (basePrimitive) SetNil()          {}
+func (basePrimitive) SetBool(bool)     {}
+func (basePrimitive) SetInt64(int64)   {}
+func (basePrimitive) SetString(string) {}
+func (basePrimitive) SetOctets(string) {}
+
+var (
+	_ Primitive        = basePrimitive{}
+	_ MutablePrimitive = basePrimitive{}
+)
@@ -0,0 +1,184 @@
+package brass
+
+import (
+	"errors"
+	"io"
+)
+
+var ErrOctetsLength = errors.New("octets length does not match data")
+
+// OctetsHandler consumes an octets atom of n octets streamed from r while it is being decoded and returns the
+// expression which replaces the atom in the decoded tree, e.g. a string naming where the data was stored. r
+// reports io.EOF after n octets. Octets not read by the handler are skipped over once it returns. A copy of the
+// returned expression is placed in the tree so that it remains unchanged when the tree is released.
+type OctetsHandler func(n int64, r io.Reader) (*SExpr, error)
+
+// StreamOctets instructs the decoder to stream octets atoms of at least min octets to h as they are decoded
+// instead of buffering them in memory. Octets used as map keys or within deferred raw expressions are never
+// streamed. A nil handler disables streaming.
+func (d *Decoder) StreamOctets(min int64, h OctetsHandler) {
+	d.octetsMin = min
+	d.octetsHandler = h
+}
+
+// streamOctets passes the data of an octets atom of the given size to the handler after the size has already
+// been read.
+func (d *Decoder) streamOctets(size uint64) (err error) {
+	r := octetsReader{d: d, n: size}
+
+	var x *SExpr
+	x, err = d.octetsHandler(int64(size), &r)
+	if err != nil {
+		return
+	}
+	if r.err != nil {
+		err = r.err
+		return
+	}
+
+	// skip over the remainder:
+	for r.n > 0 {
+		_, err = d.readHexByte()
+		if err != nil {
+			return
+		}
+		r.n--
+	}
+
+	if x == nil {
+		x = MakeNil()
+	}
+	d.ref = d.adopt(x)
+	return
+}
+
+// adopt copies an expression returned by an octets handler into nodes allocated by the decoder so that Release
+// recycles the copy rather than nodes owned by the handler.
+func (d *Decoder) adopt(x *SExpr) (e *SExpr) {
+	if x == nil {
+		return
+	}
+
+	e = d.alloc()
+	list, dict, omap := e.list, e.dict, e.omap
+	e.reset()
+	switch x.kind {
+	case KindList:
+		e.list = list[:0]
+		for _, c := range x.list {
+			e.list = append(e.list, d.adopt(c))
+		}
+	case KindMap:
+		if x.omap != nil {
+			if omap == nil {
+				omap = NewOrderedMap(len(x.omap.entries))
+			}
+			for _, en := range x.omap.entries {
+				omap.Set(en.Key, d.adopt(en.Value))
+			}
+			e.omap = omap
+			break
+		}
+		if dict == nil {
+			dict = make(map[SExprPrimitive]*SExpr, len(x.dict))
+		}
+		for k, v := range x.dict {
+			dict[k] = d.adopt(v)
+		}
+		e.dict = dict
+	case KindTagged:
+		e.tagged = d.adopt(x.tagged)
+		e.value = x.value
+	}
+	e.kind, e.integer, e.octets = x.kind, x.integer, x.octets
+	return
+}
+
+// octetsReader reads the hex-decoded data of an octets atom from a Decoder.
+type octetsReader struct {
+	d *Decoder
+	// n is the number of octets remaining:
+	n   uint64
+	err error
+}
+
+func (r *octetsReader) Read(p []byte) (n int, err error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	if r.n == 0 {
+		return 0, io.EOF
+	}
+
+	if uint64(len(p)) > r.n {
+		p = p[:r.n]
+	}
+	for n < len(p) {
+		p[n], err = r.d.readHexByte()
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			r.err = err
+			return
+		}
+		n++
+		r.n--
+	}
+	return
+}
+
+// octetsChunk is the number of octets encoded at a time by WriteOctets.
+const octetsChunk = 16 << 10
+
+// WriteOctets writes an octets atom of n octets read from r to w using a fixed amount of memory regardless of n.
+// It fails with ErrOctetsLength if r has fewer than n octets. It may be used to stream large octets within an
+// s-expression written piecewise, e.g. after writing `(write-rom ` and before writing `)`.
+func WriteOctets(w io.Writer, n int64, r io.Reader) (written int64, err error) {
+	if n < 0 {
+		err = ErrOctetsLength
+		return
+	}
+
+	var src [octetsChunk]byte
+	dst := make([]byte, 0, 32+octetsChunk*2)
+
+	dst = append(dst, '#')
+	dst = appendHex(dst, uint64(n))
+	dst = append(dst, '$')
+
+	for n > 0 {
+		k := int64(len(src))
+		if n < k {
+			k = n
+		}
+
+		var m int
+		m, err = io.ReadFull(r, src[:k])
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = ErrOctetsLength
+		}
+		if err != nil {
+			return
+		}
+		n -= int64(m)
+
+		for _, b := range src[:m] {
+			dst = append(dst, hexDigits[b>>4], hexDigits[b&15])
+		}
+
+		m, err = w.Write(dst)
+		written += int64(m)
+		if err != nil {
+			return
+		}
+		dst = dst[:0]
+	}
+
+	if len(dst) > 0 {
+		var m int
+		m, err = w.Write(dst)
+		written += int64(m)
+	}
+	return
+}
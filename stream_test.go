@@ -0,0 +1,162 @@
+package brass
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestDecoder_StreamOctets(t *testing.T) {
+	data := bytes.Repeat([]byte{0x00, 0x7f, 0x80, 0xff}, 20000)
+
+	b := bytes.Buffer{}
+	b.WriteString(`(write-rom $7e0000 `)
+	if _, err := WriteOctets(&b, int64(len(data)), bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	b.WriteString(` #2$0102 {(#1$00 #4$01020304)})`)
+	encoded := b.String()
+
+	// the streamed encoding must match the buffered encoding:
+	if want := `(write-rom $7e0000 ` + MakeOctets(data).String(); !strings.HasPrefix(encoded, want) {
+		t.Fatal("WriteOctets() encoding does not match AppendBrass")
+	}
+
+	var got []byte
+	d := NewDecoder(strings.NewReader(encoded))
+	d.StreamOctets(4, func(n int64, r io.Reader) (*SExpr, error) {
+		if n == 4 {
+			// leave the data unread:
+			return MakeString("skipped"), nil
+		}
+
+		var err error
+		got, err = io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		return MakeString("rom"), nil
+	})
+	e, err := d.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("streamed %d octets, want %d", len(got), len(data))
+	}
+	if got, want := e.String(), `(write-rom $7e0000 "rom" #2$0102 {(#1$00 "skipped")})`; got != want {
+		t.Errorf("Decode() = %s, want %s", got, want)
+	}
+}
+
+func TestDecoder_StreamOctetsRelease(t *testing.T) {
+	// the handler's expression is not recycled when the tree is released:
+	owned := MakeList([]*SExpr{MakeString("rom"), MakeMap(map[SExprPrimitive]*SExpr{PrimitiveString("a"): MakeInt64(1)})})
+	s := `(#4$01020304 ("x" "y") {("b" $2)})`
+
+	r := strings.NewReader(s)
+	d := NewDecoder(r)
+	d.StreamOctets(4, func(n int64, r io.Reader) (*SExpr, error) {
+		return owned, nil
+	})
+	for i := 0; i < 2; i++ {
+		r.Reset(s)
+		d.Reset(r)
+		e, err := d.Decode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := e.String(), `(("rom" {("a" $1)}) ("x" "y") {("b" $2)})`; got != want {
+			t.Errorf("Decode() = %s, want %s", got, want)
+		}
+		d.Release(e)
+	}
+	if got, want := owned.String(), `("rom" {("a" $1)})`; got != want {
+		t.Errorf("handler expression after Release() = %s, want %s", got, want)
+	}
+}
+
+func TestDecoder_StreamOctetsErrors(t *testing.T) {
+	handlerErr := errors.New("handler")
+	tests := []struct {
+		name    string
+		s       string
+		h       OctetsHandler
+		wantErr error
+	}{
+		{
+			name: "handler error",
+			s:    `(#2$0102)`,
+			h: func(n int64, r io.Reader) (*SExpr, error) {
+				return nil, handlerErr
+			},
+			wantErr: handlerErr,
+		},
+		{
+			name: "invalid data while reading",
+			s:    `(#2$01xx)`,
+			h: func(n int64, r io.Reader) (*SExpr, error) {
+				_, err := io.ReadAll(r)
+				return nil, err
+			},
+			wantErr: ErrUnexpectedCharacter,
+		},
+		{
+			name: "invalid data ignored by handler",
+			s:    `(#2$01xx)`,
+			h: func(n int64, r io.Reader) (*SExpr, error) {
+				io.ReadAll(r)
+				return MakeNil(), nil
+			},
+			wantErr: ErrUnexpectedCharacter,
+		},
+		{
+			name: "invalid data skipped",
+			s:    `(#2$01xx)`,
+			h: func(n int64, r io.Reader) (*SExpr, error) {
+				return MakeNil(), nil
+			},
+			wantErr: ErrUnexpectedCharacter,
+		},
+		{
+			name: "truncated",
+			s:    `(#2$01`,
+			h: func(n int64, r io.Reader) (*SExpr, error) {
+				_, err := io.ReadAll(r)
+				return nil, err
+			},
+			wantErr: io.ErrUnexpectedEOF,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDecoder(strings.NewReader(tt.s))
+			d.StreamOctets(1, tt.h)
+			if _, err := d.Decode(); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Decode() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestWriteOctets(t *testing.T) {
+	b := bytes.Buffer{}
+	n, err := WriteOctets(&b, 3, strings.NewReader("abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := b.String(), `#3$616263`; got != want || n != int64(len(want)) {
+		t.Errorf("WriteOctets() = %q, %d, want %q, %d", got, n, want, len(want))
+	}
+
+	b.Reset()
+	if _, err = WriteOctets(&b, 0, strings.NewReader("")); err != nil || b.String() != `#0$` {
+		t.Errorf("WriteOctets() = %q, %v, want %q", b.String(), err, `#0$`)
+	}
+
+	if _, err = WriteOctets(io.Discard, 4, strings.NewReader("abc")); !errors.Is(err, ErrOctetsLength) {
+		t.Errorf("WriteOctets() error = %v, want %v", err, ErrOctetsLength)
+	}
+}
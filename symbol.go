@@ -0,0 +1,53 @@
+package brass
+
+import "fmt"
+
+// isSymbolStart returns true if c may start a symbol (or a keyword).
+func isSymbolStart(c byte) bool {
+	return ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || c == '_'
+}
+
+// isSymbolChar returns true if c may appear in a symbol after its first character.
+func isSymbolChar(c byte) bool {
+	return isSymbolStart(c) || ('0' <= c && c <= '9') || c == '-' || c == '.' || c == '/'
+}
+
+// IsValidSymbol returns true if name can be encoded as a symbol atom. The keywords "nil", "true" and "false" are
+// not valid symbols.
+func IsValidSymbol(name string) bool {
+	if len(name) == 0 || !isSymbolStart(name[0]) {
+		return false
+	}
+	for i := 1; i < len(name); i++ {
+		if !isSymbolChar(name[i]) {
+			return false
+		}
+	}
+
+	switch name {
+	case "nil", "true", "false":
+		return false
+	}
+	return true
+}
+
+// mustBeSymbol panics with ErrInvalidSymbol if name is not a valid symbol.
+func mustBeSymbol(name string) {
+	if !IsValidSymbol(name) {
+		panic(fmt.Errorf("%w: %q", ErrInvalidSymbol, name))
+	}
+}
+
+// setIdentifier sets p to the keyword or symbol named by a decoded identifier.
+func setIdentifier(p mutablePrimitive, ident []byte) {
+	switch string(ident) {
+	case "nil":
+		p.SetNil()
+	case "true":
+		p.SetBool(true)
+	case "false":
+		p.SetBool(false)
+	default:
+		p.SetSymbol(string(ident))
+	}
+}
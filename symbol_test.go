@@ -0,0 +1,63 @@
+package brass
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestIsValidSymbol(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"read", true},
+		{"_", true},
+		{"Read-Mem.v2/x_y", true},
+		{"nilx", true},
+		{"", false},
+		{"nil", false},
+		{"true", false},
+		{"false", false},
+		{"2read", false},
+		{"-read", false},
+		{"re ad", false},
+		{"re\"ad", false},
+		{"re$ad", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsValidSymbol(tt.name); got != tt.want {
+				t.Errorf("IsValidSymbol() = %v, want %v", got, tt.want)
+			}
+
+			// constructors panic for invalid names:
+			constructors := map[string]func(){
+				"MakeSymbol":      func() { MakeSymbol(tt.name) },
+				"PrimitiveSymbol": func() { PrimitiveSymbol(tt.name) },
+				"SExpr.SetSymbol": func() { MakeNil().SetSymbol(tt.name) },
+				"SetSymbol":       func() { (&SExprPrimitive{}).SetSymbol(tt.name) },
+				"MakeTagged":      func() { MakeTagged(tt.name, MakeNil()) },
+			}
+			for name, f := range constructors {
+				err := recoverError(f)
+				if tt.want && err != nil {
+					t.Errorf("%s() panicked with %v", name, err)
+				}
+				if !tt.want && !errors.Is(err, ErrInvalidSymbol) && !errors.Is(err, ErrInvalidTag) {
+					t.Errorf("%s() panicked with %v, want %v", name, err, ErrInvalidSymbol)
+				}
+			}
+		})
+	}
+}
+
+// recoverError calls f and returns the error it panics with.
+func recoverError(f func()) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err, _ = r.(error)
+		}
+	}()
+	f()
+	return
+}
@@ -0,0 +1,146 @@
+package brass
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+var ErrInvalidTag = errors.New("tag must be a valid symbol")
+var ErrDuplicateTag = errors.New("tag already registered")
+var ErrUnregisteredType = errors.New("no tag registered for type")
+
+// TagCodec converts between the inner expression of a tagged value and a Go value of a specific type.
+type TagCodec struct {
+	// Type is the Go type of values returned by Decode and accepted by Encode:
+	Type reflect.Type
+	// Decode converts the inner expression to a Go value of Type:
+	Decode func(inner *SExpr) (any, error)
+	// Encode converts a Go value of Type to the inner expression:
+	Encode func(v any) (*SExpr, error)
+}
+
+// TagRegistry maps tags to the codecs which convert their tagged values to and from Go values.
+type TagRegistry struct {
+	lock   sync.RWMutex
+	byTag  map[string]*TagCodec
+	byType map[reflect.Type]string
+}
+
+func NewTagRegistry() *TagRegistry {
+	return &TagRegistry{
+		byTag:  make(map[string]*TagCodec),
+		byType: make(map[reflect.Type]string),
+	}
+}
+
+// DefaultTagRegistry is used by decoders unless another registry is selected with Decoder.UseTagRegistry.
+var DefaultTagRegistry = NewTagRegistry()
+
+// RegisterTag registers a codec for a tag in DefaultTagRegistry.
+func RegisterTag(tag string, codec TagCodec) error {
+	return DefaultTagRegistry.Register(tag, codec)
+}
+
+// Register registers a codec for a tag. Each tag and each Go type may only be registered once.
+func (r *TagRegistry) Register(tag string, codec TagCodec) error {
+	if !IsValidSymbol(tag) {
+		return ErrInvalidTag
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if _, ok := r.byTag[tag]; ok {
+		return fmt.Errorf("%w: %s", ErrDuplicateTag, tag)
+	}
+	if other, ok := r.byType[codec.Type]; ok {
+		return fmt.Errorf("%w: type %v already registered as %s", ErrDuplicateTag, codec.Type, other)
+	}
+
+	r.byTag[tag] = &codec
+	r.byType[codec.Type] = tag
+	return nil
+}
+
+// Lookup returns the codec registered for a tag.
+func (r *TagRegistry) Lookup(tag string) (codec *TagCodec, ok bool) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	codec, ok = r.byTag[tag]
+	return
+}
+
+// lookupType returns the tag and codec registered for a Go type.
+func (r *TagRegistry) lookupType(t reflect.Type) (tag string, codec *TagCodec, ok bool) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	tag, ok = r.byType[t]
+	codec = r.byTag[tag]
+	return
+}
+
+// MakeTagged makes a tagged value from a Go value whose type has a registered codec.
+func (r *TagRegistry) MakeTagged(v any) (e *SExpr, err error) {
+	tag, codec, ok := r.lookupType(reflect.TypeOf(v))
+	if !ok {
+		err = fmt.Errorf("%w: %T", ErrUnregisteredType, v)
+		return
+	}
+
+	var inner *SExpr
+	inner, err = codec.Encode(v)
+	if err != nil {
+		err = fmt.Errorf("tag %s: %w", tag, err)
+		return
+	}
+
+	e = &SExpr{kind: KindTagged, octets: tag, tagged: inner, value: v}
+	return
+}
+
+// MakeTaggedValue makes a tagged value from a Go value whose type has a codec registered in DefaultTagRegistry.
+func MakeTaggedValue(v any) (*SExpr, error) {
+	return DefaultTagRegistry.MakeTagged(v)
+}
+
+// MakeTagged makes a tagged value from a tag and its inner expression without any conversion. It panics if tag is
+// not a valid symbol; NewTagged returns an error instead.
+func MakeTagged(tag string, inner *SExpr) *SExpr {
+	if !IsValidSymbol(tag) {
+		panic(fmt.Errorf("%w: %q", ErrInvalidTag, tag))
+	}
+	return &SExpr{kind: KindTagged, octets: tag, tagged: inner}
+}
+
+// UseTagRegistry selects the registry used to convert decoded tagged values to Go values.
+func (d *Decoder) UseTagRegistry(r *TagRegistry) {
+	d.tags = r
+}
+
+// setTagged sets e to a tagged value and converts its inner expression if the tag is registered in r.
+func setTagged(e *SExpr, r *TagRegistry, tag string, inner *SExpr) (err error) {
+	e.reset()
+	e.kind = KindTagged
+	e.octets = tag
+	e.tagged = inner
+
+	if r == nil {
+		r = DefaultTagRegistry
+	}
+	codec, ok := r.Lookup(tag)
+	if !ok {
+		// unknown tags are preserved as is:
+		return
+	}
+
+	e.value, err = codec.Decode(inner)
+	if err != nil {
+		err = fmt.Errorf("tag %s: %w", tag, err)
+		return
+	}
+	return
+}
@@ -0,0 +1,140 @@
+package brass
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func testTagRegistry(t *testing.T) *TagRegistry {
+	r := NewTagRegistry()
+	err := r.Register("time", TagCodec{
+		Type: reflect.TypeOf(time.Time{}),
+		Decode: func(inner *SExpr) (any, error) {
+			if inner.Kind() != KindInteger {
+				return nil, errors.New("expected integer")
+			}
+			return time.Unix(inner.AsInt64(), 0).UTC(), nil
+		},
+		Encode: func(v any) (*SExpr, error) {
+			return MakeInt64(v.(time.Time).Unix()), nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestTagRegistry_Register(t *testing.T) {
+	r := testTagRegistry(t)
+
+	codec := TagCodec{Type: reflect.TypeOf(0)}
+	if err := r.Register("nil", codec); !errors.Is(err, ErrInvalidTag) {
+		t.Errorf("Register() error = %v, want %v", err, ErrInvalidTag)
+	}
+	if err := r.Register("time", codec); !errors.Is(err, ErrDuplicateTag) {
+		t.Errorf("Register() error = %v, want %v", err, ErrDuplicateTag)
+	}
+	if err := r.Register("time2", TagCodec{Type: reflect.TypeOf(time.Time{})}); !errors.Is(err, ErrDuplicateTag) {
+		t.Errorf("Register() error = %v, want %v", err, ErrDuplicateTag)
+	}
+}
+
+func TestDecoder_Tagged(t *testing.T) {
+	tests := []struct {
+		name      string
+		s         string
+		wantValue any
+		wantErr   bool
+	}{
+		{
+			name:      "registered",
+			s:         "(@time($5f5e1000))",
+			wantValue: time.Unix(0x5f5e1000, 0).UTC(),
+		},
+		{
+			name:      "unregistered",
+			s:         `(@uuid(#4$01020304))`,
+			wantValue: nil,
+		},
+		{
+			name:      "nested",
+			s:         `(@point(($1 $2)))`,
+			wantValue: nil,
+		},
+		{
+			name:    "conversion error",
+			s:       `(@time("x"))`,
+			wantErr: true,
+		},
+		{
+			name:    "empty",
+			s:       `(@time())`,
+			wantErr: true,
+		},
+		{
+			name:    "keyword tag",
+			s:       `(@nil($1))`,
+			wantErr: true,
+		},
+		{
+			name:    "key",
+			s:       `({(@time($1) $1)})`,
+			wantErr: true,
+		},
+	}
+	r := testTagRegistry(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDecoder(bytes.NewBufferString(tt.s))
+			d.UseTagRegistry(r)
+			e, err := d.Decode()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Decode() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			// the skipping decoder must agree on validity:
+			d = NewDecoder(bytes.NewBufferString(tt.s))
+			_, rawErr := d.DecodeRaw()
+			if tt.wantErr {
+				if rawErr == nil && tt.name != "conversion error" {
+					t.Errorf("DecodeRaw() error = nil, want error")
+				}
+				return
+			}
+			if rawErr != nil {
+				t.Fatalf("DecodeRaw() error = %v", rawErr)
+			}
+
+			tagged := e.AsList()[0]
+			if got := tagged.TaggedValue(); !reflect.DeepEqual(got, tt.wantValue) {
+				t.Errorf("TaggedValue() = %v, want %v", got, tt.wantValue)
+			}
+			if got := e.String(); got != tt.s {
+				t.Errorf("String() = %v, want %v", got, tt.s)
+			}
+		})
+	}
+}
+
+func TestTagRegistry_MakeTagged(t *testing.T) {
+	r := testTagRegistry(t)
+
+	e, err := r.MakeTagged(time.Unix(0x10, 0))
+	if err != nil {
+		t.Fatalf("MakeTagged() error = %v", err)
+	}
+	if got, want := e.String(), "@time($10)"; got != want {
+		t.Errorf("String() = %v, want %v", got, want)
+	}
+	if tag, inner := e.AsTagged(); tag != "time" || inner.AsInt64() != 0x10 {
+		t.Errorf("AsTagged() = %v, %v", tag, inner)
+	}
+
+	if _, err = r.MakeTagged(1); !errors.Is(err, ErrUnregisteredType) {
+		t.Errorf("MakeTagged() error = %v, want %v", err, ErrUnregisteredType)
+	}
+}
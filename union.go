@@ -0,0 +1,316 @@
+package brass
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+var ErrDuplicateVariant = errors.New("variant already registered")
+var ErrUnregisteredVariant = errors.New("variant not registered")
+var ErrNotInterface = errors.New("union type must be an interface")
+
+// UnionLayout selects how the variants of a Union are encoded.
+type UnionLayout int
+
+const (
+	// UnionList encodes a variant as a list of its name followed by its struct fields in order, e.g.
+	// ("Move" $1 $2). Variants which are not structs are encoded as their name followed by their value.
+	UnionList UnionLayout = iota
+	// UnionMap encodes a variant as its struct map with an additional entry for its name under TypeKey, e.g.
+	// {("type" "Move") ("x" $1) ("y" $2)}. Variants which are not structs, or are tuple structs, are encoded
+	// under ValueKey.
+	UnionMap
+)
+
+// Union maps the concrete types of the values of a Go interface type to variant names so that interface values
+// are marshaled with their variant name and unmarshaled to the variant's concrete type.
+type Union struct {
+	// Interface is the Go interface type:
+	Interface reflect.Type
+	Layout    UnionLayout
+	// TypeKey and ValueKey name the map entries of the UnionMap layout:
+	TypeKey  string
+	ValueKey string
+
+	lock   sync.RWMutex
+	byName map[string]reflect.Type
+	byType map[reflect.Type]string
+}
+
+// NewUnion creates a union for an interface type, e.g. NewUnion(reflect.TypeOf((*Message)(nil)).Elem(),
+// UnionList). The UnionMap layout uses the keys "type" and "value" by default.
+func NewUnion(iface reflect.Type, layout UnionLayout) *Union {
+	return &Union{
+		Interface: iface,
+		Layout:    layout,
+		TypeKey:   "type",
+		ValueKey:  "value",
+		byName:    make(map[string]reflect.Type),
+		byType:    make(map[reflect.Type]string),
+	}
+}
+
+// Register registers a concrete type which implements the interface under a variant name. Each name and each
+// type may only be registered once.
+func (u *Union) Register(name string, t reflect.Type) error {
+	if !t.Implements(u.Interface) {
+		return fmt.Errorf("%w: %v does not implement %v", ErrUnrepresentable, t, u.Interface)
+	}
+
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	if _, ok := u.byName[name]; ok {
+		return fmt.Errorf("%w: %s", ErrDuplicateVariant, name)
+	}
+	if other, ok := u.byType[t]; ok {
+		return fmt.Errorf("%w: type %v already registered as %s", ErrDuplicateVariant, t, other)
+	}
+
+	u.byName[name] = t
+	u.byType[t] = name
+	return nil
+}
+
+func (u *Union) variantOf(t reflect.Type) (name string, ok bool) {
+	u.lock.RLock()
+	defer u.lock.RUnlock()
+
+	name, ok = u.byType[t]
+	return
+}
+
+func (u *Union) variantNamed(name string) (t reflect.Type, ok bool) {
+	u.lock.RLock()
+	defer u.lock.RUnlock()
+
+	t, ok = u.byName[name]
+	return
+}
+
+var unions sync.Map
+
+// RegisterUnion registers a union so that Marshal, Unmarshal and Decoder.DecodeInto use it for values of its
+// interface type. Variants may be registered with the union before or after it is registered.
+func RegisterUnion(u *Union) error {
+	if u.Interface.Kind() != reflect.Interface {
+		return fmt.Errorf("%w: %v", ErrNotInterface, u.Interface)
+	}
+	if _, loaded := unions.LoadOrStore(u.Interface, u); loaded {
+		return fmt.Errorf("%w: union for %v", ErrDuplicateVariant, u.Interface)
+	}
+	return nil
+}
+
+// lookupUnion returns the union registered for an interface type.
+func lookupUnion(t reflect.Type) (u *Union, ok bool) {
+	var x any
+	x, ok = unions.Load(t)
+	if !ok {
+		return
+	}
+
+	u = x.(*Union)
+	return
+}
+
+// marshalVariant marshals the non-nil value of an interface type with a registered union.
+func (u *Union) marshalVariant(path Path, v reflect.Value) (e *SExpr, err error) {
+	name, ok := u.variantOf(v.Type())
+	if !ok {
+		err = atPath(path, fmt.Errorf("%w: %v in %v", ErrUnregisteredVariant, v.Type(), u.Interface))
+		return
+	}
+
+	// variant struct fields are encoded rather than pointers to them:
+	sv := v
+	for sv.Kind() == reflect.Pointer && !sv.IsNil() {
+		sv = sv.Elem()
+	}
+	isStruct := sv.Kind() == reflect.Struct && u.variantIsStruct(v.Type())
+
+	switch u.Layout {
+	case UnionList:
+		list := []*SExpr{MakeString(name)}
+		if isStruct {
+			var fields []*SExpr
+			fields, err = marshalTuple(path, sv, 1)
+			if err != nil {
+				return
+			}
+			list = append(list, fields...)
+		} else {
+			var x *SExpr
+			x, err = marshal(append(path, PathElem{Index: 1}), v)
+			if err != nil {
+				return
+			}
+			list = append(list, x)
+		}
+		return MakeList(list), nil
+	default:
+		typeKey := PrimitiveString(u.TypeKey)
+
+		var x *SExpr
+		if !isStruct {
+			valueKey := PrimitiveString(u.ValueKey)
+			x, err = marshal(append(path, PathElem{IsKey: true, Key: valueKey}), v)
+			if err != nil {
+				return
+			}
+			x = MakeMap(map[SExprPrimitive]*SExpr{valueKey: x})
+		} else {
+			x, err = marshalStruct(path, sv)
+			if err != nil {
+				return
+			}
+		}
+
+		if _, ok := x.dict[typeKey]; ok {
+			err = atPath(path, fmt.Errorf("%w: %v has a field named %q", ErrDuplicateVariant, v.Type(), u.TypeKey))
+			return
+		}
+		x.dict[typeKey] = MakeString(name)
+		return x, nil
+	}
+}
+
+// variantIsStruct returns true if the variant type is a struct, or a pointer to one, whose fields are encoded by
+// the union rather than by a marshaler or tag codec. Tuple structs are encoded as values under ValueKey by the
+// UnionMap layout since they are lists rather than maps.
+func (u *Union) variantIsStruct(t reflect.Type) bool {
+	st := t
+	for st.Kind() == reflect.Pointer {
+		st = st.Elem()
+	}
+	if st.Kind() != reflect.Struct {
+		return false
+	}
+	if u.Layout == UnionMap && cachedStructInfo(st).tuple {
+		return false
+	}
+
+	for _, x := range []reflect.Type{t, st, reflect.PointerTo(st)} {
+		if x.Implements(marshalerType) || x.Implements(unmarshalerType) {
+			return false
+		}
+	}
+	if _, _, ok := DefaultTagRegistry.lookupType(t); ok {
+		return false
+	}
+	return true
+}
+
+// marshalTuple marshals the fields of a struct in order, omitting trailing omitempty fields which have empty
+// values. offset is the index of the first field within its list for error paths.
+func marshalTuple(path Path, v reflect.Value, offset int) (list []*SExpr, err error) {
+	info := cachedStructInfo(v.Type())
+
+	n := len(info.fields)
+	for n > 0 && info.fields[n-1].omitEmpty && isEmptyValue(v.FieldByIndex(info.fields[n-1].index)) {
+		n--
+	}
+
+	list = make([]*SExpr, n)
+	for i := range list {
+		list[i], err = marshal(append(path, PathElem{Index: offset + i}), v.FieldByIndex(info.fields[i].index))
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// unmarshalVariant unmarshals a variant into a value of an interface type with a registered union.
+func (u *Union) unmarshalVariant(path Path, e *SExpr, v reflect.Value) (err error) {
+	var name, x *SExpr
+	var rest []*SExpr
+	switch u.Layout {
+	case UnionList:
+		if e.kind != KindList || len(e.list) == 0 {
+			return atPath(path, fmt.Errorf("%w: expected variant list for %v, got %v", ErrWrongKind, u.Interface, e.kind))
+		}
+		name, rest = e.list[0], e.list[1:]
+	default:
+		if e.kind != KindMap {
+			return mismatch(path, e, u.Interface)
+		}
+		var ok bool
+		name, ok = mapValue(e, PrimitiveString(u.TypeKey))
+		if !ok {
+			return atPath(path, fmt.Errorf("%w: missing %q entry for %v", ErrWrongKind, u.TypeKey, u.Interface))
+		}
+		x = e
+	}
+
+	if name.kind != KindString {
+		return atPath(path, fmt.Errorf("%w: variant name must be a string, got %v", ErrWrongKind, name.kind))
+	}
+	t, ok := u.variantNamed(name.octets)
+	if !ok {
+		return atPath(path, fmt.Errorf("%w: %q in %v", ErrUnregisteredVariant, name.octets, u.Interface))
+	}
+
+	// decode into a new value of the variant's type, or the struct it points to:
+	pv := reflect.New(t)
+	sv := pv.Elem()
+	for sv.Kind() == reflect.Pointer {
+		sv.Set(reflect.New(sv.Type().Elem()))
+		sv = sv.Elem()
+	}
+	isStruct := u.variantIsStruct(t)
+
+	switch {
+	case u.Layout == UnionList && isStruct:
+		err = unmarshalTuple(path, rest, sv, 1)
+	case u.Layout == UnionList:
+		if len(rest) != 1 {
+			return atPath(path, fmt.Errorf("%w: expected 1 value for variant %s, got %d", ErrWrongKind, name.octets, len(rest)))
+		}
+		err = unmarshal(append(path, PathElem{Index: 1}), rest[0], pv.Elem())
+	case isStruct:
+		err = unmarshal(path, x, sv)
+	default:
+		valueKey := PrimitiveString(u.ValueKey)
+		var value *SExpr
+		value, ok = mapValue(x, valueKey)
+		if !ok {
+			value = MakeNil()
+		}
+		err = unmarshal(append(path, PathElem{IsKey: true, Key: valueKey}), value, pv.Elem())
+	}
+	if err != nil {
+		return
+	}
+
+	v.Set(pv.Elem())
+	return
+}
+
+// unmarshalTuple unmarshals list elements into the fields of a struct in order, leaving fields beyond the end of
+// the list unchanged. offset is the index of the first element within its list for error paths.
+func unmarshalTuple(path Path, list []*SExpr, v reflect.Value, offset int) (err error) {
+	info := cachedStructInfo(v.Type())
+	if len(list) > len(info.fields) {
+		return atPath(path, fmt.Errorf("%w: too many fields for %v", ErrUnrepresentable, v.Type()))
+	}
+
+	for i, c := range list {
+		err = unmarshal(append(path, PathElem{Index: offset + i}), c, v.FieldByIndex(info.fields[i].index))
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// mapValue returns the value of a map entry.
+func mapValue(e *SExpr, k SExprPrimitive) (v *SExpr, ok bool) {
+	if e.omap != nil {
+		return e.omap.Get(k)
+	}
+	v, ok = e.dict[k]
+	return
+}
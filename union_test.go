@@ -0,0 +1,244 @@
+package brass
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type command interface{ isCommand() }
+
+type move struct {
+	X int `brass:"x"`
+	Y int `brass:"y,omitempty"`
+}
+
+type say string
+
+type quit struct {
+	Code int `brass:"code"`
+}
+
+type jump struct{}
+
+type turn struct {
+	_   struct{} `brass:",tuple"`
+	Deg int
+}
+
+func (move) isCommand()  {}
+func (say) isCommand()   {}
+func (*quit) isCommand() {}
+func (jump) isCommand()  {}
+func (turn) isCommand()  {}
+
+type shape interface{ area() int }
+
+type square struct {
+	Side int `brass:"side"`
+}
+
+type tally int
+
+type segment struct {
+	_    struct{} `brass:",tuple"`
+	From int
+	To   int
+}
+
+func (s square) area() int  { return s.Side * s.Side }
+func (n tally) area() int   { return int(n) }
+func (s segment) area() int { return s.To - s.From }
+
+type script struct {
+	Commands []command `brass:"commands"`
+	Shape    shape     `brass:"shape"`
+}
+
+func init() {
+	u := NewUnion(reflect.TypeOf((*command)(nil)).Elem(), UnionList)
+	for _, err := range []error{
+		u.Register("move", reflect.TypeOf(move{})),
+		u.Register("say", reflect.TypeOf(say(""))),
+		u.Register("quit", reflect.TypeOf(&quit{})),
+		u.Register("turn", reflect.TypeOf(turn{})),
+		RegisterUnion(u),
+	} {
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	u = NewUnion(reflect.TypeOf((*shape)(nil)).Elem(), UnionMap)
+	u.TypeKey = "kind"
+	for _, err := range []error{
+		u.Register("square", reflect.TypeOf(square{})),
+		u.Register("tally", reflect.TypeOf(tally(0))),
+		u.Register("segment", reflect.TypeOf(segment{})),
+		RegisterUnion(u),
+	} {
+		if err != nil {
+			panic(err)
+		}
+	}
+}
+
+func TestUnion(t *testing.T) {
+	tests := []struct {
+		name string
+		v    script
+		want string
+	}{
+		{
+			name: "list",
+			v:    script{Commands: []command{move{X: 1}, say("hi"), &quit{Code: 2}, nil}},
+			want: `{("commands" (("move" $1) ("say" "hi") ("quit" $2) nil)) ("shape" nil)}`,
+		},
+		{
+			name: "list tuple",
+			v:    script{Commands: []command{turn{Deg: 90}}},
+			want: `{("commands" (("turn" $5a))) ("shape" nil)}`,
+		},
+		{
+			name: "map struct",
+			v:    script{Shape: square{Side: 3}},
+			want: `{("commands" nil) ("shape" {("kind" "square") ("side" $3)})}`,
+		},
+		{
+			name: "map value",
+			v:    script{Shape: tally(4)},
+			want: `{("commands" nil) ("shape" {("kind" "tally") ("value" $4)})}`,
+		},
+		{
+			name: "map tuple",
+			v:    script{Shape: segment{From: 1, To: 4}},
+			want: `{("commands" nil) ("shape" {("kind" "segment") ("value" ($1 $4))})}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := Marshal(tt.v)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(e, mustDecodeText(t, tt.want)) {
+				t.Errorf("Marshal() = %s, want %s", e, tt.want)
+			}
+
+			var got script
+			if err = Unmarshal(e, &got); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.v) {
+				t.Errorf("Unmarshal() = %+v, want %+v", got, tt.v)
+			}
+
+			var into []script
+			if err = NewDecoder(strings.NewReader("(" + tt.want + ")")).DecodeInto(&into); err != nil {
+				t.Fatal(err)
+			}
+			if len(into) != 1 || !reflect.DeepEqual(into[0], tt.v) {
+				t.Errorf("DecodeInto() = %+v, want %+v", into, tt.v)
+			}
+		})
+	}
+}
+
+func TestUnion_DecodeInto(t *testing.T) {
+	var got []script
+	s := `({("commands" (("move" $1) ("quit" $2))) ("shape" {("side" $3) ("kind" "square")})})`
+	if err := NewDecoder(strings.NewReader(s)).DecodeInto(&got); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []script{{Commands: []command{move{X: 1}, &quit{Code: 2}}, Shape: square{Side: 3}}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("DecodeInto() = %+v, want %+v", got, want)
+	}
+}
+
+func TestUnion_Errors(t *testing.T) {
+	if _, err := Marshal(script{Commands: []command{jump{}}}); !errors.Is(err, ErrUnregisteredVariant) {
+		t.Errorf("Marshal() error = %v, want %v", err, ErrUnregisteredVariant)
+	}
+
+	tests := []struct {
+		name    string
+		s       string
+		wantErr error
+		wantMsg string
+	}{
+		{
+			name:    "unregistered",
+			s:       `{("commands" (("jump")))}`,
+			wantErr: ErrUnregisteredVariant,
+			wantMsg: `["commands"][0]: variant not registered: "jump" in brass.command`,
+		},
+		{
+			name:    "not a list",
+			s:       `{("commands" ("move"))}`,
+			wantErr: ErrWrongKind,
+		},
+		{
+			name:    "name kind",
+			s:       `{("commands" ((move $1)))}`,
+			wantErr: ErrWrongKind,
+		},
+		{
+			name:    "too many fields",
+			s:       `{("commands" (("move" $1 $2 $3)))}`,
+			wantErr: ErrUnrepresentable,
+		},
+		{
+			name:    "field kind",
+			s:       `{("commands" (("move" "a")))}`,
+			wantErr: ErrWrongKind,
+			wantMsg: `["commands"][0][1]: wrong kind: cannot unmarshal KindString into int`,
+		},
+		{
+			name:    "missing type key",
+			s:       `{("shape" {("side" $1)})}`,
+			wantErr: ErrWrongKind,
+		},
+		{
+			name:    "unregistered map",
+			s:       `{("shape" {("kind" "circle")})}`,
+			wantErr: ErrUnregisteredVariant,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got script
+			err := Unmarshal(mustDecodeText(t, tt.s), &got)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Unmarshal() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantMsg != "" && err.Error() != tt.wantMsg {
+				t.Errorf("Unmarshal() error = %q, want %q", err, tt.wantMsg)
+			}
+		})
+	}
+}
+
+func TestUnion_Register(t *testing.T) {
+	u := NewUnion(reflect.TypeOf((*command)(nil)).Elem(), UnionList)
+	if err := u.Register("move", reflect.TypeOf(move{})); err != nil {
+		t.Fatal(err)
+	}
+	if err := u.Register("move", reflect.TypeOf(say(""))); !errors.Is(err, ErrDuplicateVariant) {
+		t.Errorf("Register() error = %v, want %v", err, ErrDuplicateVariant)
+	}
+	if err := u.Register("walk", reflect.TypeOf(move{})); !errors.Is(err, ErrDuplicateVariant) {
+		t.Errorf("Register() error = %v, want %v", err, ErrDuplicateVariant)
+	}
+	if err := u.Register("square", reflect.TypeOf(square{})); !errors.Is(err, ErrUnrepresentable) {
+		t.Errorf("Register() error = %v, want %v", err, ErrUnrepresentable)
+	}
+	if err := RegisterUnion(u); !errors.Is(err, ErrDuplicateVariant) {
+		t.Errorf("RegisterUnion() error = %v, want %v", err, ErrDuplicateVariant)
+	}
+	if err := RegisterUnion(NewUnion(reflect.TypeOf(move{}), UnionList)); !errors.Is(err, ErrNotInterface) {
+		t.Errorf("RegisterUnion() error = %v, want %v", err, ErrNotInterface)
+	}
+}
@@ -0,0 +1,238 @@
+package brass
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SkipChildren may be returned by a pre-order WalkFunc to skip the children of the current list or map.
+var SkipChildren = errors.New("skip children")
+
+// StopWalk may be returned by a WalkFunc to stop walking; Walk then returns nil.
+var StopWalk = errors.New("stop walk")
+
+// PathElem is a single step from a list, map or tagged value to one of its children.
+type PathElem struct {
+	// IsKey is set if the step is into a map value by Key; otherwise it is into a list element by Index:
+	IsKey bool
+	Index int
+	Key   SExprPrimitive
+	// Tag is set if the step is into the inner expression of a tagged value instead:
+	Tag string
+}
+
+// Path locates an expression within a tree as the sequence of steps taken from the root.
+type Path []PathElem
+
+// String formats the path as a sequence of bracketed list indices and encoded map keys and tags of tagged values,
+// e.g. `[1]["a"]@point[0]`.
+func (p Path) String() string {
+	sb := strings.Builder{}
+	for _, el := range p {
+		if el.Tag != "" {
+			sb.WriteByte('@')
+			sb.WriteString(el.Tag)
+			continue
+		}
+
+		sb.WriteByte('[')
+		if el.IsKey {
+			el.Key.AppendTo(&sb)
+		} else {
+			sb.WriteString(strconv.Itoa(el.Index))
+		}
+		sb.WriteByte(']')
+	}
+	return sb.String()
+}
+
+// WalkFunc is called for each expression visited by Walk along with its path from the root. The path is reused
+// between calls and must be copied to be retained.
+type WalkFunc func(path Path, e *SExpr) error
+
+// Walk visits every expression in the tree rooted at e in depth-first order, calling pre before visiting the
+// children of an expression and post afterwards. Either function may be nil. List elements and entries of ordered
+// maps are visited in order; entries of unordered maps are visited in ascending key order. The inner expression of
+// a tagged value is its only child. Raw expressions are visited but not descended into.
+//
+// If pre returns SkipChildren then the children of the expression are not visited but post is still called for
+// it. If either function returns StopWalk then walking stops and Walk returns nil. Any other error stops walking
+// and is returned by Walk.
+func Walk(e *SExpr, pre, post WalkFunc) (err error) {
+	err = walk(make(Path, 0, 8), e, pre, post)
+	if err == StopWalk {
+		err = nil
+	}
+	return
+}
+
+func walk(path Path, e *SExpr, pre, post WalkFunc) (err error) {
+	skip := false
+	if pre != nil {
+		err = pre(path, e)
+		if err == SkipChildren {
+			skip = true
+			err = nil
+		}
+		if err != nil {
+			return
+		}
+	}
+
+	if !skip {
+		switch e.kind {
+		case KindList:
+			for i, c := range e.list {
+				err = walk(append(path, PathElem{Index: i}), c, pre, post)
+				if err != nil {
+					return
+				}
+			}
+		case KindMap:
+			for _, en := range mapEntries(e) {
+				err = walk(append(path, PathElem{IsKey: true, Key: en.Key}), en.Value, pre, post)
+				if err != nil {
+					return
+				}
+			}
+		case KindTagged:
+			err = walk(append(path, PathElem{Tag: e.octets}), e.tagged, pre, post)
+			if err != nil {
+				return
+			}
+		}
+	}
+
+	if post != nil {
+		err = post(path, e)
+	}
+	return
+}
+
+// TransformFunc is called for each expression visited by Transform and returns its replacement, which may be e
+// itself. Returning nil removes the expression from its parent list or map. Returning nil for the inner expression
+// of a tagged value removes the tagged value.
+type TransformFunc func(path Path, e *SExpr) (*SExpr, error)
+
+// Transform rewrites the tree rooted at e bottom-up: fn is called for each expression after its children have
+// been transformed. The original tree is not modified; lists, maps and tagged values are copied only when any of
+// their children are replaced, so unchanged subtrees are shared with the original. A copied tagged value has no Go
+// value converted by a TagRegistry.
+func Transform(e *SExpr, fn TransformFunc) (*SExpr, error) {
+	return transform(make(Path, 0, 8), e, fn)
+}
+
+func transform(path Path, e *SExpr, fn TransformFunc) (r *SExpr, err error) {
+	switch e.kind {
+	case KindList:
+		var list []*SExpr
+		for i, c := range e.list {
+			var x *SExpr
+			x, err = transform(append(path, PathElem{Index: i}), c, fn)
+			if err != nil {
+				return
+			}
+
+			if list == nil && x != c {
+				// copy on first change:
+				list = make([]*SExpr, i, len(e.list))
+				copy(list, e.list[:i])
+			}
+			if list != nil && x != nil {
+				list = append(list, x)
+			}
+		}
+		if list != nil {
+			e = MakeList(list)
+		}
+	case KindMap:
+		entries := mapEntries(e)
+		var changed []MapEntry
+		for i, en := range entries {
+			var x *SExpr
+			x, err = transform(append(path, PathElem{IsKey: true, Key: en.Key}), en.Value, fn)
+			if err != nil {
+				return
+			}
+
+			if changed == nil && x != en.Value {
+				// copy on first change:
+				changed = make([]MapEntry, i, len(entries))
+				copy(changed, entries[:i])
+			}
+			if changed != nil && x != nil {
+				changed = append(changed, MapEntry{Key: en.Key, Value: x})
+			}
+		}
+		if changed != nil {
+			if e.omap != nil {
+				m := NewOrderedMap(len(changed))
+				for _, en := range changed {
+					m.Set(en.Key, en.Value)
+				}
+				e = MakeOrderedMap(m)
+			} else {
+				dict := make(map[SExprPrimitive]*SExpr, len(changed))
+				for _, en := range changed {
+					dict[en.Key] = en.Value
+				}
+				e = MakeMap(dict)
+			}
+		}
+	case KindTagged:
+		var x *SExpr
+		x, err = transform(append(path, PathElem{Tag: e.octets}), e.tagged, fn)
+		if err != nil || x == nil {
+			return
+		}
+		if x != e.tagged {
+			e = MakeTagged(e.octets, x)
+		}
+	}
+
+	return fn(path, e)
+}
+
+// mapEntries returns the entries of a map in order for ordered maps or else in ascending key order.
+func mapEntries(e *SExpr) []MapEntry {
+	if e.omap != nil {
+		return e.omap.entries
+	}
+
+	entries := make([]MapEntry, 0, len(e.dict))
+	for _, k := range sortedKeys(e.dict) {
+		entries = append(entries, MapEntry{Key: k, Value: e.dict[k]})
+	}
+	return entries
+}
+
+// sortedKeys returns the keys of a map in ascending order as defined by comparePrimitives.
+func sortedKeys(dict map[SExprPrimitive]*SExpr) []SExprPrimitive {
+	keys := make([]SExprPrimitive, 0, len(dict))
+	for k := range dict {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return comparePrimitives(&keys[i], &keys[j]) < 0
+	})
+	return keys
+}
+
+// comparePrimitives orders primitives first by kind and then by value.
+func comparePrimitives(a, b *SExprPrimitive) int {
+	if a.kind != b.kind {
+		if a.kind < b.kind {
+			return -1
+		}
+		return 1
+	}
+	if a.integer != b.integer {
+		if a.integer < b.integer {
+			return -1
+		}
+		return 1
+	}
+	return strings.Compare(a.octets, b.octets)
+}
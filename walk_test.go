@@ -0,0 +1,170 @@
+package brass
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func walkTestExpr() *SExpr {
+	return MakeList([]*SExpr{
+		MakeString("a"),
+		MakeList([]*SExpr{MakeInt64(1), MakeOctets([]byte{1, 2, 3})}),
+		MakeMap(map[SExprPrimitive]*SExpr{
+			PrimitiveString("y"): MakeOctets([]byte{4}),
+			PrimitiveString("x"): MakeString("secret"),
+			PrimitiveInt64(2):    MakeNil(),
+		}),
+	})
+}
+
+func TestWalk(t *testing.T) {
+	tests := []struct {
+		name     string
+		pre      func(path Path, e *SExpr) error
+		wantPre  []string
+		wantPost []string
+		wantErr  error
+	}{
+		{
+			name:     "all",
+			wantPre:  []string{"", "[0]", "[1]", "[1][0]", "[1][1]", "[2]", "[2][$2]", `[2]["x"]`, `[2]["y"]`},
+			wantPost: []string{"[0]", "[1][0]", "[1][1]", "[1]", "[2][$2]", `[2]["x"]`, `[2]["y"]`, "[2]", ""},
+		},
+		{
+			name: "skip",
+			pre: func(path Path, e *SExpr) error {
+				if e.Kind() == KindMap {
+					return SkipChildren
+				}
+				return nil
+			},
+			wantPre:  []string{"", "[0]", "[1]", "[1][0]", "[1][1]", "[2]"},
+			wantPost: []string{"[0]", "[1][0]", "[1][1]", "[1]", "[2]", ""},
+		},
+		{
+			name: "stop",
+			pre: func(path Path, e *SExpr) error {
+				if e.Kind() == KindOctets {
+					return StopWalk
+				}
+				return nil
+			},
+			wantPre:  []string{"", "[0]", "[1]", "[1][0]", "[1][1]"},
+			wantPost: []string{"[0]", "[1][0]"},
+		},
+		{
+			name: "error",
+			pre: func(path Path, e *SExpr) error {
+				if e.Kind() == KindInteger {
+					return errTestWalk
+				}
+				return nil
+			},
+			wantPre:  []string{"", "[0]", "[1]", "[1][0]"},
+			wantPost: []string{"[0]"},
+			wantErr:  errTestWalk,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotPre, gotPost []string
+			err := Walk(
+				walkTestExpr(),
+				func(path Path, e *SExpr) error {
+					gotPre = append(gotPre, path.String())
+					if tt.pre != nil {
+						return tt.pre(path, e)
+					}
+					return nil
+				},
+				func(path Path, e *SExpr) error {
+					gotPost = append(gotPost, path.String())
+					return nil
+				},
+			)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Walk() error = %v, want %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(gotPre, tt.wantPre) {
+				t.Errorf("Walk() pre = %q, want %q", gotPre, tt.wantPre)
+			}
+			if !reflect.DeepEqual(gotPost, tt.wantPost) {
+				t.Errorf("Walk() post = %q, want %q", gotPost, tt.wantPost)
+			}
+		})
+	}
+}
+
+var errTestWalk = errors.New("test")
+
+func TestTransform(t *testing.T) {
+	orig := walkTestExpr()
+
+	// redact strings under the "x" key and drop nils:
+	got, err := Transform(orig, func(path Path, e *SExpr) (*SExpr, error) {
+		if e.Kind() == KindNil {
+			return nil, nil
+		}
+		if len(path) > 0 && path[len(path)-1].IsKey && path[len(path)-1].Key == PrimitiveString("x") {
+			return MakeString(strings.Repeat("*", len(e.AsString()))), nil
+		}
+		return e, nil
+	})
+	if err != nil {
+		t.Fatalf("Transform() error = %v", err)
+	}
+
+	if !reflect.DeepEqual(orig, walkTestExpr()) {
+		t.Errorf("Transform() modified original = %v", orig)
+	}
+
+	m := got.AsList()[2].AsMap()
+	if len(m) != 2 || m[PrimitiveString("x")].AsString() != "******" {
+		t.Errorf("Transform() = %v", got)
+	}
+	if got.AsList()[1] != orig.AsList()[1] {
+		t.Errorf("Transform() did not share unchanged subtree")
+	}
+}
+
+func TestWalk_Tagged(t *testing.T) {
+	e := MakeList([]*SExpr{
+		MakeTagged("point", MakeList([]*SExpr{MakeInt64(1), MakeOctets([]byte{2})})),
+		MakeTagged("id", MakeOctets([]byte{3})),
+	})
+
+	var got []string
+	err := Walk(e, func(path Path, e *SExpr) error {
+		got = append(got, path.String())
+		return nil
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"", "[0]", "[0]@point", "[0]@point[0]", "[0]@point[1]", "[1]", "[1]@id"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Walk() = %q, want %q", got, want)
+	}
+
+	// redact octets within tagged values and remove tagged values with nothing else inside:
+	r, err := Transform(e, func(path Path, e *SExpr) (*SExpr, error) {
+		if e.Kind() == KindOctets {
+			if path[len(path)-1].Tag != "" {
+				return nil, nil
+			}
+			return MakeOctets(nil), nil
+		}
+		return e, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := r.String(), `(@point(($1 #0$)))`; got != want {
+		t.Errorf("Transform() = %v, want %v", got, want)
+	}
+	if got, want := e.String(), `(@point(($1 #1$02)) @id(#1$03))`; got != want {
+		t.Errorf("Transform() modified original = %v, want %v", got, want)
+	}
+}